@@ -1,2 +0,0 @@
-#!/bin/bash
-cat ./out.txt | grep ACTIVE | wc -l
\ No newline at end of file
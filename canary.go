@@ -0,0 +1,463 @@
+// canary.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+// canarySteps are the steps of a canary run, in order.
+var canarySteps = []string{"query", "upload", "download", "delete"}
+
+var (
+	canaryLabels       = []string{"group", "step"}
+	canaryStepDuration = newDesc(
+		prometheus.BuildFQName(namespace, "canary", "step_duration_seconds"),
+		"How long the steps of the canary took.",
+		canaryLabels, nil,
+	)
+	canaryStepSuccess = newDesc(
+		prometheus.BuildFQName(namespace, "canary", "step_success"),
+		"Whether the step succeeded in the last canary run against the group.",
+		canaryLabels, nil,
+	)
+	canaryLastRun = newDesc(
+		prometheus.BuildFQName(namespace, "canary", "last_run_timestamp_seconds"),
+		"When the canary last ran against the group.",
+		[]string{"group"}, nil,
+	)
+	replicationLabels  = []string{"group", "source", "destination"}
+	replicationLatency = newDesc(
+		prometheus.BuildFQName(namespace, "replication", "latency_seconds"),
+		"How long after its upload the canary appeared on the destination.",
+		replicationLabels, nil,
+	)
+	replicationMissed = newDesc(
+		prometheus.BuildFQName(namespace, "replication", "missed_total"),
+		"How many canaries had not reached the destination by the replication deadline.",
+		replicationLabels, nil,
+	)
+)
+
+// replicationBuckets span the seconds to minutes FastDFS takes to sync.
+var replicationBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// replicationPollInterval is how often the members of a group are asked
+// whether the canary reached them.
+const replicationPollInterval = time.Second
+
+// canaryExt is the extension of canary files, so they can be told apart
+// from user files should a delete fail.
+const canaryExt = "canary"
+
+// canary checks that a cluster serves clients: on a schedule it asks a
+// tracker for a store server of every group, uploads a small file there,
+// downloads and verifies it, waits for it to reach the other ACTIVE
+// members of the group, then deletes it again.
+type canary struct {
+	interval            time.Duration
+	timeout             time.Duration
+	size                int
+	replicationDeadline time.Duration
+	httpProbe           bool
+	httpFileGroup       string
+	httpFileName        string
+
+	mu          sync.Mutex
+	durations   map[canaryStep]*histogram
+	success     map[canaryStep]bool
+	lastRun     map[string]time.Time
+	replication map[replicaPair]*histogram
+	missed      map[replicaPair]float64
+
+	httpStatus    map[httpEndpoint]int
+	httpMatch     map[httpEndpoint]bool
+	httpDurations map[httpEndpoint]*histogram
+}
+
+type canaryStep struct {
+	group, step string
+}
+
+// replicaPair is a storage the canary was uploaded to and a member of its
+// group it is replicated to, both by host:port.
+type replicaPair struct {
+	group, source, destination string
+}
+
+// canaryResult is the outcome of one step of a canary run.
+type canaryResult struct {
+	step     string
+	duration time.Duration
+	err      error
+}
+
+// newCanary returns the canary configured by c, or nil when it is off.
+func newCanary(c FastDFSConfig) *canary {
+	if c.CanaryInterval <= 0 {
+		return nil
+	}
+	// newTarget has checked the file ID.
+	group, name, _ := splitFileID(c.HTTPProbeFile)
+	return &canary{
+		interval:            c.CanaryInterval,
+		timeout:             c.CanaryTimeout,
+		size:                c.CanarySize,
+		replicationDeadline: c.CanaryReplicationDeadline,
+		httpProbe:           c.HTTPProbe || c.HTTPProbeFile != "",
+		httpFileGroup:       group,
+		httpFileName:        name,
+		durations:           map[canaryStep]*histogram{},
+		success:             map[canaryStep]bool{},
+		lastRun:             map[string]time.Time{},
+		replication:         map[replicaPair]*histogram{},
+		missed:              map[replicaPair]float64{},
+		httpStatus:          map[httpEndpoint]int{},
+		httpMatch:           map[httpEndpoint]bool{},
+		httpDurations:       map[httpEndpoint]*histogram{},
+	}
+}
+
+// run probes the cluster of t every interval until stop is closed. state
+// returns what the last collection learned about the cluster.
+func (c *canary) run(t *target, state func() clusterState, stop <-chan struct{}) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		c.probe(ctx, t, state())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs the canary against every group of the cluster in parallel.
+// When the groups cannot be listed every step of the known groups is
+// marked as failed.
+func (c *canary) probe(ctx context.Context, t *target, state clusterState) {
+	if c.httpFileName != "" {
+		c.probeKnownFile(ctx, state)
+	}
+	lctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	// Discovery errors are logged by trackerAddresses; the configured
+	// trackers may still answer.
+	trackers, _ := t.trackerAddresses(lctx)
+	// With fdfs_monitor alone the trackers are those it printed.
+	if len(trackers) == 0 && state.cluster != nil {
+		trackers = state.cluster.Trackers
+	}
+	var groups []string
+	err := withTracker(lctx, trackers, func(tracker *fdfs.Tracker) error {
+		stats, err := tracker.ListGroups()
+		for _, g := range stats {
+			groups = append(groups, g.Name)
+		}
+		return err
+	})
+	if err != nil {
+		log.Errorf("Canary could not list the groups: %v", err)
+		for _, group := range c.groups() {
+			c.record(group, nil)
+			if c.httpProbe && c.httpFileName == "" {
+				c.failHTTP(state, group)
+			}
+		}
+		return
+	}
+	c.forget(groups)
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			c.record(group, c.probeGroup(ctx, trackers, state, group))
+		}(group)
+	}
+	wg.Wait()
+}
+
+// probeGroup runs the steps of the canary against group, stopping at the
+// first failure. Once the upload succeeded the file is always deleted.
+// Each step may take at most the canary timeout. Without an upload there is
+// nothing to download over HTTP, and the HTTP probe counts as failed.
+func (c *canary) probeGroup(ctx context.Context, trackers []string, state clusterState, group string) []canaryResult {
+	var results []canaryResult
+	httpProbed := false
+	defer func() {
+		if c.httpProbe && c.httpFileName == "" && !httpProbed {
+			c.failHTTP(state, group)
+		}
+	}()
+	step := func(name string, fn func(ctx context.Context) error) bool {
+		sctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		start := time.Now()
+		err := fn(sctx)
+		results = append(results, canaryResult{name, time.Since(start), err})
+		if err != nil {
+			log.Errorf("Canary %s in group %s failed: %v", name, group, err)
+		}
+		return err == nil
+	}
+
+	var store fdfs.StoreServer
+	if !step("query", func(ctx context.Context) error {
+		return withTracker(ctx, trackers, func(tracker *fdfs.Tracker) (err error) {
+			store, err = tracker.QueryStore(group)
+			return err
+		})
+	}) {
+		return results
+	}
+
+	data := make([]byte, c.size)
+	if _, err := rand.Read(data); err != nil {
+		log.Errorf("Canary could not make its content: %v", err)
+		return results
+	}
+	var uploaded, name string
+	if !step("upload", func(ctx context.Context) error {
+		return withStorage(ctx, store.Addr(), func(storage *fdfs.Storage) (err error) {
+			uploaded, name, err = storage.Upload(store.StorePathIndex, canaryExt, data)
+			return err
+		})
+	}) {
+		return results
+	}
+	uploadTime := time.Now()
+	// A bad download still leaves the file to check and clean up.
+	step("download", func(ctx context.Context) error {
+		return withStorage(ctx, store.Addr(), func(storage *fdfs.Storage) error {
+			got, err := storage.Download(uploaded, name)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, data) {
+				return fmt.Errorf("downloaded %d bytes differ from the %d uploaded to %s/%s", len(got), len(data), uploaded, name)
+			}
+			return nil
+		})
+	})
+	if c.replicationDeadline > 0 {
+		c.replicate(ctx, state.cluster, store, uploaded, name, uploadTime)
+	}
+	if c.httpProbe && c.httpFileName == "" {
+		c.probeHTTP(ctx, state, uploaded, name, func(body []byte) bool {
+			return bytes.Equal(body, data)
+		})
+		httpProbed = true
+	}
+	step("delete", func(ctx context.Context) error {
+		return withStorage(ctx, store.Addr(), func(storage *fdfs.Storage) error {
+			return storage.Delete(uploaded, name)
+		})
+	})
+	return results
+}
+
+// replicate waits until the file uploaded to store at uploadTime reached
+// every other ACTIVE member of its group, as known from the last
+// collection, and records how long each took. Members still missing it at
+// the replication deadline count as missed.
+func (c *canary) replicate(ctx context.Context, cluster *Cluster, store fdfs.StoreServer, group, name string, uploadTime time.Time) {
+	if cluster == nil {
+		return
+	}
+	ctx, cancel := context.WithDeadline(ctx, uploadTime.Add(c.replicationDeadline))
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, g := range cluster.Groups {
+		if g.Name != group {
+			continue
+		}
+		for _, s := range g.Storages {
+			addr, ok := g.StorageAddr(s)
+			if !ok || s.Status != "ACTIVE" || addr == store.Addr() {
+				continue
+			}
+			pair := replicaPair{group, store.Addr(), addr}
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				if err := waitForFile(ctx, addr, group, name); err != nil {
+					log.Errorf("Canary %s/%s did not replicate from %s to %s: %v", group, name, pair.source, pair.destination, err)
+					c.recordReplication(pair, 0, false)
+					return
+				}
+				c.recordReplication(pair, time.Since(uploadTime), true)
+			}(addr)
+		}
+	}
+	wg.Wait()
+}
+
+// waitForFile polls the storage at addr until it has the file or ctx is
+// done. In the latter case it returns the error of the last poll that was
+// not cut short by ctx.
+func waitForFile(ctx context.Context, addr, group, name string) error {
+	ticker := time.NewTicker(replicationPollInterval)
+	defer ticker.Stop()
+	var last error
+	for {
+		err := withStorage(ctx, addr, func(storage *fdfs.Storage) error {
+			_, err := storage.QueryFileInfo(group, name)
+			return err
+		})
+		if err == nil {
+			return nil
+		}
+		// The dialer gives up at the deadline slightly before ctx is done.
+		if deadline, ok := ctx.Deadline(); ctx.Err() == nil && (!ok || time.Now().Before(deadline)) {
+			last = err
+		}
+		select {
+		case <-ctx.Done():
+			if last == nil {
+				last = ctx.Err()
+			}
+			return last
+		case <-ticker.C:
+		}
+	}
+}
+
+// withStorage calls fn with a connection to the storage server at addr.
+func withStorage(ctx context.Context, addr string, fn func(*fdfs.Storage) error) error {
+	storage, err := fdfs.DialStorageContext(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	return fn(storage)
+}
+
+// record stores the results of a canary run against group. Steps that
+// did not run count as failed.
+func (c *canary) record(group string, results []canaryResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRun[group] = time.Now()
+	for _, step := range canarySteps {
+		c.success[canaryStep{group, step}] = false
+	}
+	for _, r := range results {
+		k := canaryStep{group, r.step}
+		c.success[k] = r.err == nil
+		h := c.durations[k]
+		if h == nil {
+			h = newHistogram(prometheus.DefBuckets)
+			c.durations[k] = h
+		}
+		h.observe(r.duration.Seconds())
+	}
+}
+
+// recordReplication stores whether the canary reached the destination of
+// pair, and how long it took.
+func (c *canary) recordReplication(pair replicaPair, latency time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.replication[pair]
+	if h == nil {
+		h = newHistogram(replicationBuckets)
+		c.replication[pair] = h
+	}
+	if ok {
+		h.observe(latency.Seconds())
+	} else {
+		c.missed[pair]++
+	}
+}
+
+// groups returns the groups the canary has run against.
+func (c *canary) groups() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	groups := make([]string, 0, len(c.lastRun))
+	for group := range c.lastRun {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// forget drops the results of groups that are no longer in the cluster.
+func (c *canary) forget(current []string) {
+	keep := map[string]bool{}
+	for _, group := range current {
+		keep[group] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for group := range c.lastRun {
+		if keep[group] {
+			continue
+		}
+		delete(c.lastRun, group)
+		for _, step := range canarySteps {
+			delete(c.success, canaryStep{group, step})
+			delete(c.durations, canaryStep{group, step})
+		}
+	}
+	for pair := range c.replication {
+		if !keep[pair.group] {
+			delete(c.replication, pair)
+			delete(c.missed, pair)
+		}
+	}
+	for e := range c.httpStatus {
+		if !keep[e.group] {
+			delete(c.httpStatus, e)
+			delete(c.httpMatch, e)
+			delete(c.httpDurations, e)
+		}
+	}
+}
+
+func describeCanaryMetrics(ch chan<- *prometheus.Desc) {
+	ch <- canaryStepDuration
+	ch <- canaryStepSuccess
+	ch <- canaryLastRun
+	ch <- replicationLatency
+	ch <- replicationMissed
+	describeHTTPProbeMetrics(ch)
+}
+
+func (c *canary) collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, ok := range c.success {
+		var success float64
+		if ok {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(canaryStepSuccess, prometheus.GaugeValue, success, k.group, k.step)
+	}
+	for k, h := range c.durations {
+		ch <- h.metric(canaryStepDuration, k.group, k.step)
+	}
+	for group, t := range c.lastRun {
+		ch <- prometheus.MustNewConstMetric(canaryLastRun, prometheus.GaugeValue, float64(t.UnixNano())/1e9, group)
+	}
+	for pair, h := range c.replication {
+		ch <- h.metric(replicationLatency, pair.group, pair.source, pair.destination)
+		ch <- prometheus.MustNewConstMetric(replicationMissed, prometheus.CounterValue, c.missed[pair], pair.group, pair.source, pair.destination)
+	}
+	c.collectHTTP(ch)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+// newCanaryTest returns a canary and a target without trackers of their
+// own, and a fake tracker serving group1 from m. The tracker is found
+// through state, the way fdfs_monitor reports it.
+func newCanaryTest(t *testing.T, m *member) (*canary, *target, *fdfstest.Tracker, clusterState) {
+	tracker, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.AddGroup(fdfs.GroupStat{Name: "group1", StorageCount: 1, ActiveCount: 1}, m.Stat("s1"))
+	state := stateOf("group1", m)
+	state.cluster.Trackers = []string{tracker.Addr}
+
+	c := newCanary(FastDFSConfig{
+		CanaryInterval: time.Minute,
+		CanaryTimeout:  time.Second,
+		CanarySize:     64,
+	})
+	tg := &target{config: FastDFSConfig{Executor: "local"}, executor: executor.NewFake()}
+	return c, tg, tracker, state
+}
+
+// steps returns the success of the canary steps against group1.
+func steps(query, upload, download, delete float64) map[string]float64 {
+	return map[string]float64{
+		"group=group1,step=query":    query,
+		"group=group1,step=upload":   upload,
+		"group=group1,step=download": download,
+		"group=group1,step=delete":   delete,
+	}
+}
+
+func TestCanaryProbe(t *testing.T) {
+	m := newMember(t, "group1")
+	defer m.Close()
+	c, tg, tracker, state := newCanaryTest(t, m)
+	defer tracker.Close()
+
+	c.probe(context.Background(), tg, state)
+	metrics := gatherFunc(c.collect)
+	if got, want := values(t, metrics, canaryStepSuccess), steps(1, 1, 1, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("step success = %v, want %v", got, want)
+	}
+	if got := values(t, metrics, canaryLastRun); len(got) != 1 {
+		t.Errorf("last runs = %v, want one for group1", got)
+	}
+	if files := m.Files(); len(files) != 0 {
+		t.Errorf("the canary left %d files behind", len(files))
+	}
+}
+
+func TestCanaryProbeUploadFails(t *testing.T) {
+	m := newMember(t, "group1")
+	defer m.Close()
+	c, tg, tracker, state := newCanaryTest(t, m)
+	defer tracker.Close()
+	m.Fail(fdfs.StorageProtoCmdUploadFile, 28) // ENOSPC
+
+	c.probe(context.Background(), tg, state)
+	metrics := gatherFunc(c.collect)
+	if got, want := values(t, metrics, canaryStepSuccess), steps(1, 0, 0, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("step success = %v, want %v", got, want)
+	}
+	want := map[string]float64{"group=group1,step=query": 1, "group=group1,step=upload": 1}
+	if got := values(t, metrics, canaryStepDuration); !reflect.DeepEqual(got, want) {
+		t.Errorf("step durations = %v, want only the steps that ran", got)
+	}
+}
+
+func TestCanaryProbeCorruptDownload(t *testing.T) {
+	m := newMember(t, "group1")
+	defer m.Close()
+	c, tg, tracker, state := newCanaryTest(t, m)
+	defer tracker.Close()
+	m.Corrupt(true)
+
+	c.probe(context.Background(), tg, state)
+	if got, want := values(t, gatherFunc(c.collect), canaryStepSuccess), steps(1, 1, 0, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("step success = %v, want %v", got, want)
+	}
+	if files := m.Files(); len(files) != 0 {
+		t.Errorf("the corrupt canary was not deleted, %d files are left", len(files))
+	}
+}
+
+func TestCanaryProbeForgetsRemovedGroups(t *testing.T) {
+	m1, m2 := newMember(t, "group1"), newMember(t, "group2")
+	defer m1.Close()
+	defer m2.Close()
+	c, tg, tracker, state := newCanaryTest(t, m1)
+	defer tracker.Close()
+	tracker.AddGroup(fdfs.GroupStat{Name: "group2", StorageCount: 1, ActiveCount: 1}, m2.Stat("s2"))
+
+	c.probe(context.Background(), tg, state)
+	if got := values(t, gatherFunc(c.collect), canaryLastRun); len(got) != 2 {
+		t.Fatalf("last runs = %v, want group1 and group2", got)
+	}
+
+	tracker.RemoveGroup("group2")
+	c.probe(context.Background(), tg, state)
+	metrics := gatherFunc(c.collect)
+	if got, want := values(t, metrics, canaryStepSuccess), steps(1, 1, 1, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("step success after group2 was removed = %v, want %v", got, want)
+	}
+	for _, k := range []string{"group=group1", "group=group2"} {
+		if _, ok := values(t, metrics, canaryLastRun)[k]; ok != (k == "group=group1") {
+			t.Errorf("last run of %s exported %v after group2 was removed", k, ok)
+		}
+	}
+}
+
+func TestCanaryProbeReplication(t *testing.T) {
+	m1, m2 := newMember(t, "group1"), newMember(t, "group1")
+	defer m1.Close()
+	defer m2.Close()
+	m1.Replicate(0, m2.Storage)
+	c, tg, tracker, _ := newCanaryTest(t, m1)
+	defer tracker.Close()
+	state := stateOf("group1", m1, m2)
+	state.cluster.Trackers = []string{tracker.Addr}
+	c.replicationDeadline = 5 * time.Second
+
+	c.probe(context.Background(), tg, state)
+	metrics := gatherFunc(c.collect)
+	pair := "destination=" + m2.Addr + ",group=group1,source=" + m1.Addr
+	if got, want := values(t, metrics, replicationLatency), map[string]float64{pair: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replication latencies = %v, want %v", got, want)
+	}
+	if got, want := values(t, metrics, replicationMissed), map[string]float64{pair: 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missed replications = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,288 @@
+// collection.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// collectionConfig controls background collection for the clusters served
+// on /metrics. With a zero interval every scrape queries the clusters.
+type collectionConfig struct {
+	interval    time.Duration
+	maxAge      time.Duration
+	stalePolicy string
+}
+
+var collection collectionConfig
+
+func (c collectionConfig) validate() error {
+	if c.stalePolicy != "serve" && c.stalePolicy != "drop" {
+		return fmt.Errorf("unknown stale policy %q, want serve or drop", c.stalePolicy)
+	}
+	if c.interval < 0 || c.maxAge < 0 {
+		return fmt.Errorf("collection interval and max age must not be negative")
+	}
+	return nil
+}
+
+var lastCollection = newDesc(
+	prometheus.BuildFQName(namespace, "", "last_collection_timestamp_seconds"),
+	"When the last successful collection from the cluster finished.",
+	nil, nil,
+)
+
+// cache holds the metrics of the last successful background collection,
+// and the collection in progress when scrapes collect on the spot. The
+// metrics about the collection itself always come from the latest one.
+// The state of the cluster seen by the last successful collection is kept
+// for the canary.
+type cache struct {
+	mu      sync.Mutex
+	state   clusterState
+	metrics []prometheus.Metric
+	meta    []prometheus.Metric
+	time    time.Time
+	failed  bool
+	polling bool
+	flight  *flight
+}
+
+// flight is a collection in progress, shared by the scrapes that arrive
+// while it runs. waiters counts the scrapes still waiting for it and is
+// guarded by the cache mutex.
+type flight struct {
+	done    chan struct{}
+	ctx     *flightContext
+	waiters int
+	metrics []prometheus.Metric
+	meta    []prometheus.Metric
+	err     error
+}
+
+// flightContext is the context of a shared collection. It has no deadline
+// of its own: it ends when the last scrape waiting for the collection gives
+// up, with the error of that scrape's context. So the collection lasts until
+// the latest deadline of the scrapes that joined it, and fails with
+// context.DeadlineExceeded, counted as a timeout, when that one passes.
+type flightContext struct {
+	context.Context
+	done chan struct{}
+	mu   sync.Mutex
+	err  error
+}
+
+func newFlightContext() *flightContext {
+	return &flightContext{Context: context.Background(), done: make(chan struct{})}
+}
+
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *flightContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// end ends the context with err, unless it has already ended.
+func (c *flightContext) end(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+// start runs the background work of the exporter until stop is called: a
+// collection every collection interval, after which scrapes are served
+// from the last collection, and the canary when it is configured.
+func (e *Exporter) start() {
+	e.done = make(chan struct{})
+	if collection.interval > 0 {
+		e.cache.polling = true
+		go e.poll(e.done)
+	}
+	if e.canary != nil {
+		go e.canary.run(e.target, e.clusterState, e.done)
+	}
+}
+
+// clusterState is what a successful collection learned about the cluster:
+// its topology and, when it was read, FastDFS.json.
+type clusterState struct {
+	cluster *Cluster
+	config  *ConfigInfoJSON
+}
+
+// clusterState returns the state seen by the last successful collection,
+// which is empty before the first one.
+func (e *Exporter) clusterState() clusterState {
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	return e.cache.state
+}
+
+func (e *Exporter) stop() {
+	if e.done != nil {
+		close(e.done)
+	}
+}
+
+func (e *Exporter) poll(stop <-chan struct{}) {
+	ticker := time.NewTicker(collection.interval)
+	defer ticker.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		e.refresh(ctx)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh replaces the cached metrics with a new collection. A failed
+// collection keeps the previous metrics, which serve then hands out
+// according to the stale policy. A collection may take at most one interval.
+func (e *Exporter) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, collection.interval)
+	defer cancel()
+	metrics, meta, err := e.gather(ctx)
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	e.cache.meta = meta
+	if err != nil {
+		log.Errorf("Collection failed, keeping the metrics from %v: %v", e.cache.time, err)
+		e.cache.failed = true
+		return
+	}
+	e.cache.metrics, e.cache.time, e.cache.failed = metrics, time.Now(), false
+}
+
+// gather runs collect into a slice, returning the metrics about the
+// collection separately. Metrics are immutable once created, so the slices
+// can be handed to any number of scrapes.
+func (e *Exporter) gather(ctx context.Context) (metrics, meta []prometheus.Metric, err error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		close(done)
+	}()
+	stats, err := e.collect(ctx, ch)
+	close(ch)
+	<-done
+	return metrics, e.scrapeMetrics(stats, err), err
+}
+
+// collectShared collects from the cluster, or joins the collection already
+// running, so concurrent scrapes query the cluster once. The collection runs
+// on a flightContext, which ends once every scrape waiting for it has given
+// up, so it lasts at most as long as the latest scrape deadline and one
+// impatient scrape cannot fail the others. The errors of a collection that
+// ran out of time are counted by the collection itself.
+func (e *Exporter) collectShared(ctx context.Context) (metrics, meta []prometheus.Metric, err error) {
+	e.cache.mu.Lock()
+	f := e.cache.flight
+	if f == nil {
+		f = &flight{done: make(chan struct{}), ctx: newFlightContext()}
+		e.cache.flight = f
+		go e.fly(f)
+	}
+	f.waiters++
+	e.cache.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.metrics, f.meta, f.err
+	case <-ctx.Done():
+		e.cache.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.ctx.end(ctx.Err())
+			if e.cache.flight == f {
+				e.cache.flight = nil
+			}
+		}
+		e.cache.mu.Unlock()
+		return nil, e.scrapeMetrics(newScrapeStats(), ctx.Err()), ctx.Err()
+	}
+}
+
+// fly runs the collection of f and hands the result to its waiters.
+func (e *Exporter) fly(f *flight) {
+	defer f.ctx.end(context.Canceled)
+	f.metrics, f.meta, f.err = e.gather(f.ctx)
+
+	e.cache.mu.Lock()
+	if e.cache.flight == f {
+		e.cache.flight = nil
+	}
+	if f.err == nil {
+		e.cache.time = time.Now()
+	}
+	e.cache.mu.Unlock()
+	close(f.done)
+}
+
+// serve sends the cached metrics to ch, or collects them on the spot when
+// there is no background collection.
+func (e *Exporter) serve(ctx context.Context, ch chan<- prometheus.Metric) {
+	var metrics, meta []prometheus.Metric
+	if !e.cache.polling {
+		var err error
+		if metrics, meta, err = e.collectShared(ctx); err != nil {
+			log.Error(err)
+		}
+	} else {
+		e.cache.mu.Lock()
+		failed, last := e.cache.failed, e.cache.time
+		if !failed || collection.serveStale(last) {
+			metrics = e.cache.metrics
+		}
+		meta = e.cache.meta
+		e.cache.mu.Unlock()
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+	for _, m := range meta {
+		ch <- m
+	}
+	e.cache.mu.Lock()
+	last := e.cache.time
+	e.cache.mu.Unlock()
+	if !last.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			lastCollection, prometheus.GaugeValue, float64(last.UnixNano())/1e9,
+		)
+	}
+	if e.canary != nil {
+		e.canary.collect(ch)
+	}
+}
+
+// serveStale reports whether metrics collected at t are still served after
+// the cluster became unreachable.
+func (c collectionConfig) serveStale(t time.Time) bool {
+	if c.stalePolicy == "drop" {
+		return false
+	}
+	return c.maxAge == 0 || time.Since(t) < c.maxAge
+}
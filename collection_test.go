@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+// newTrackerExporter returns an exporter collecting from the tracker at addr
+// without running any command.
+func newTrackerExporter(t *testing.T, addr string) *Exporter {
+	target := &target{
+		config:   FastDFSConfig{Executor: "local", TrackerServers: []string{addr}},
+		executor: executor.NewFake(),
+	}
+	e, err := NewExporter(target, nil, []string{"tracker", "topology", "group", "storage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func newFakeTracker(t *testing.T) *fdfstest.Tracker {
+	tracker, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.AddGroup(
+		fdfs.GroupStat{Name: "group1", StorageCount: 1, ActiveCount: 1, StoragePort: 23000},
+		fdfs.StorageStat{Status: fdfs.StorageStatusActive, ID: "s1", IPAddr: "10.0.0.1", StoragePort: 23000},
+	)
+	return tracker
+}
+
+func collectAll(ctx context.Context, c contextCollector) []prometheus.Metric {
+	return gatherFunc(func(ch chan<- prometheus.Metric) {
+		c.CollectContext(ctx, ch)
+	})
+}
+
+// gatherFunc returns the metrics collect sends.
+func gatherFunc(collect func(chan<- prometheus.Metric)) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// render returns the metrics as sorted strings, for comparing collections.
+func render(t *testing.T, metrics []prometheus.Metric) []string {
+	var out []string
+	for _, m := range metrics {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Error(err)
+			continue
+		}
+		out = append(out, m.Desc().String()+" "+pb.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// gaugeValue returns the value of the first metric with desc, and whether
+// there was one.
+func gaugeValue(t *testing.T, metrics []prometheus.Metric, desc *prometheus.Desc) (float64, bool) {
+	for _, m := range metrics {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		return pb.GetGauge().GetValue(), true
+	}
+	return 0, false
+}
+
+// values returns the values of the metrics with desc, keyed by their labels
+// as name=value pairs in name order, joined by commas. Histograms yield
+// their sample count.
+func values(t *testing.T, metrics []prometheus.Metric, desc *prometheus.Desc) map[string]float64 {
+	out := map[string]float64{}
+	for _, m := range metrics {
+		if m.Desc() != desc {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, l := range pb.GetLabel() {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		var v float64
+		switch {
+		case pb.Gauge != nil:
+			v = pb.GetGauge().GetValue()
+		case pb.Counter != nil:
+			v = pb.GetCounter().GetValue()
+		case pb.Histogram != nil:
+			v = float64(pb.GetHistogram().GetSampleCount())
+		}
+		out[strings.Join(labels, ",")] = v
+	}
+	return out
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (e *Exporter) flightWaiters() int {
+	e.cache.mu.Lock()
+	defer e.cache.mu.Unlock()
+	if e.cache.flight == nil {
+		return 0
+	}
+	return e.cache.flight.waiters
+}
+
+func TestCollectContextShared(t *testing.T) {
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	e := newTrackerExporter(t, tracker.Addr)
+
+	collectAll(context.Background(), e)
+	perCollection := tracker.Requests()
+	if perCollection == 0 {
+		t.Fatal("a collection sent no request to the tracker")
+	}
+
+	const scrapes = 20
+	release := tracker.Hold()
+	defer release()
+	results := make(chan []string, scrapes)
+	for i := 0; i < scrapes; i++ {
+		go func() {
+			results <- render(t, collectAll(context.Background(), e))
+		}()
+	}
+	waitFor(t, "every scrape to join the collection", func() bool {
+		return e.flightWaiters() == scrapes
+	})
+	release()
+
+	first := <-results
+	for i := 1; i < scrapes; i++ {
+		if got := <-results; !reflect.DeepEqual(got, first) {
+			t.Errorf("scrape %d got\n%v\nwant\n%v", i, got, first)
+		}
+	}
+	if got, want := tracker.Requests(), 2*perCollection; got != want {
+		t.Errorf("tracker got %d requests, want %d for two collections", got, want)
+	}
+}
+
+func TestCollectContextWaiterGivesUp(t *testing.T) {
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	e := newTrackerExporter(t, tracker.Addr)
+
+	release := tracker.Hold()
+	defer release()
+	patient := make(chan []prometheus.Metric, 1)
+	go func() {
+		patient <- collectAll(context.Background(), e)
+	}()
+	waitFor(t, "the first scrape to start the collection", func() bool {
+		return e.flightWaiters() == 1
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	impatient := make(chan []prometheus.Metric, 1)
+	go func() {
+		impatient <- collectAll(ctx, e)
+	}()
+	waitFor(t, "the second scrape to join the collection", func() bool {
+		return e.flightWaiters() == 2
+	})
+	cancel()
+	if v, ok := gaugeValue(t, <-impatient, up); !ok || v != 0 {
+		t.Errorf("the scrape that gave up got up %v (found %v), want 0", v, ok)
+	}
+
+	release()
+	if v, ok := gaugeValue(t, <-patient, up); !ok || v != 1 {
+		t.Errorf("the scrape that waited got up %v (found %v), want 1", v, ok)
+	}
+}
+
+func TestCollectContextAllWaitersGiveUp(t *testing.T) {
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	e := newTrackerExporter(t, tracker.Addr)
+
+	release := tracker.Hold()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []prometheus.Metric, 1)
+	go func() {
+		done <- collectAll(ctx, e)
+	}()
+	waitFor(t, "the scrape to start the collection", func() bool {
+		return e.flightWaiters() == 1
+	})
+	cancel()
+	<-done
+	release()
+
+	// The abandoned collection must not be joined by later scrapes.
+	if v, ok := gaugeValue(t, collectAll(context.Background(), e), up); !ok || v != 1 {
+		t.Errorf("the next scrape got up %v (found %v), want 1", v, ok)
+	}
+}
+
+func TestCollectContextDeadlineCountsAsTimeout(t *testing.T) {
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	e := newTrackerExporter(t, tracker.Addr)
+
+	release := tracker.Hold()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if v, ok := gaugeValue(t, collectAll(ctx, e), up); !ok || v != 0 {
+		t.Errorf("the scrape past its deadline got up %v (found %v), want 0", v, ok)
+	}
+	release()
+
+	timeout := stageError{stageTracker, "timeout"}
+	waitFor(t, "the abandoned collection to count its error", func() bool {
+		e.errors.mu.Lock()
+		defer e.errors.mu.Unlock()
+		return e.errors.counts[timeout] > 0
+	})
+	e.errors.mu.Lock()
+	defer e.errors.mu.Unlock()
+	if want := map[stageError]float64{timeout: 1}; !reflect.DeepEqual(e.errors.counts, want) {
+		t.Errorf("got errors %v, want %v", e.errors.counts, want)
+	}
+}
+
+// withCollection sets the collection config for a test and returns a
+// function restoring the previous one.
+func withCollection(c collectionConfig) func() {
+	old := collection
+	collection = c
+	return func() { collection = old }
+}
+
+func TestServeStale(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		config collectionConfig
+		last   time.Time
+		want   bool
+	}{
+		{collectionConfig{stalePolicy: "serve"}, now.Add(-time.Hour), true},
+		{collectionConfig{stalePolicy: "serve", maxAge: time.Minute}, now.Add(-30 * time.Second), true},
+		{collectionConfig{stalePolicy: "serve", maxAge: time.Minute}, now.Add(-2 * time.Minute), false},
+		{collectionConfig{stalePolicy: "drop"}, now, false},
+	} {
+		if got := c.config.serveStale(c.last); got != c.want {
+			t.Errorf("%+v serving data from %v ago = %v, want %v", c.config, now.Sub(c.last), got, c.want)
+		}
+	}
+}
+
+func TestRefreshFailure(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config collectionConfig
+		age    time.Duration
+		stale  bool
+	}{
+		{"serve", collectionConfig{interval: time.Minute, stalePolicy: "serve"}, 0, true},
+		{"serve within max age", collectionConfig{interval: time.Minute, stalePolicy: "serve", maxAge: time.Hour}, 0, true},
+		{"serve past max age", collectionConfig{interval: time.Minute, stalePolicy: "serve", maxAge: time.Hour}, 2 * time.Hour, false},
+		{"drop", collectionConfig{interval: time.Minute, stalePolicy: "drop"}, 0, false},
+	} {
+		restore := withCollection(c.config)
+		tracker := newFakeTracker(t)
+		e := newTrackerExporter(t, tracker.Addr)
+		e.cache.polling = true
+
+		e.refresh(context.Background())
+		metrics := collectAll(context.Background(), e)
+		if v, ok := gaugeValue(t, metrics, up); !ok || v != 1 {
+			t.Errorf("%s: up after a successful refresh = %v (found %v), want 1", c.name, v, ok)
+		}
+		last, ok := gaugeValue(t, metrics, lastCollection)
+		if !ok {
+			t.Errorf("%s: no last collection time after a successful refresh", c.name)
+		}
+
+		tracker.Close()
+		e.refresh(context.Background())
+		e.cache.mu.Lock()
+		e.cache.time = e.cache.time.Add(-c.age)
+		e.cache.mu.Unlock()
+		metrics = collectAll(context.Background(), e)
+		if v, ok := gaugeValue(t, metrics, up); !ok || v != 0 {
+			t.Errorf("%s: up after a failed refresh = %v (found %v), want 0", c.name, v, ok)
+		}
+		if _, ok := gaugeValue(t, metrics, groupCount); ok != c.stale {
+			t.Errorf("%s: served the previous topology %v, want %v", c.name, ok, c.stale)
+		}
+		// The time of the last success is kept through failures.
+		if v, ok := gaugeValue(t, metrics, lastCollection); !ok || math.Abs(v-(last-c.age.Seconds())) > 1e-3 {
+			t.Errorf("%s: last collection time after a failed refresh = %v (found %v), want %v", c.name, v, ok, last-c.age.Seconds())
+		}
+		if errs := values(t, metrics, scrapeErrors); errs["cause=unreachable,stage=tracker"] != 1 {
+			t.Errorf("%s: got errors %v, want the unreachable tracker", c.name, errs)
+		}
+		restore()
+	}
+}
+
+func TestPollServesFromCache(t *testing.T) {
+	defer withCollection(collectionConfig{interval: time.Hour, stalePolicy: "serve"})()
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	e := newTrackerExporter(t, tracker.Addr)
+	e.start()
+	defer e.stop()
+
+	waitFor(t, "the first background collection", func() bool {
+		e.cache.mu.Lock()
+		defer e.cache.mu.Unlock()
+		return !e.cache.time.IsZero()
+	})
+	requests := tracker.Requests()
+	for i := 0; i < 3; i++ {
+		if v, ok := gaugeValue(t, collectAll(context.Background(), e), up); !ok || v != 1 {
+			t.Errorf("scrape %d: up = %v (found %v), want 1", i+1, v, ok)
+		}
+	}
+	if got := tracker.Requests(); got != requests {
+		t.Errorf("scrapes sent %d requests to the tracker, want none", got-requests)
+	}
+}
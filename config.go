@@ -0,0 +1,168 @@
+// config.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// allCollectors are the optional parts of a collection, all enabled unless a
+// cluster lists the ones it wants. The group count and storage state counts
+// are always collected.
+var allCollectors = []string{"config", "tracker", "topology", "group", "storage", "liveness"}
+
+// ConfigFile is the file given by --config.file.
+type ConfigFile struct {
+	Clusters []ClusterConfig   `yaml:"clusters"`
+	Modules  map[string]Module `yaml:"modules"`
+}
+
+// ClusterConfig is a FastDFS cluster collected by /metrics. Its metrics
+// carry a cluster label with its name and its constant labels.
+type ClusterConfig struct {
+	Name          string            `yaml:"name"`
+	Labels        map[string]string `yaml:"labels"`
+	Collectors    []string          `yaml:"collectors"`
+	FastDFSConfig `yaml:",inline"`
+}
+
+func (c *ClusterConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = ClusterConfig{Collectors: allCollectors, FastDFSConfig: defaultConfig}
+	type plain ClusterConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Name == "" {
+		return fmt.Errorf("cluster without a name")
+	}
+	if _, ok := c.Labels["cluster"]; ok {
+		return fmt.Errorf("cluster %s: the cluster label is set from the name", c.Name)
+	}
+	if err := checkConstLabels(c.Labels); err != nil {
+		return fmt.Errorf("cluster %s: %v", c.Name, err)
+	}
+	for _, name := range c.Collectors {
+		if !knownCollector(name) {
+			return fmt.Errorf("cluster %s: unknown collector %q, want one of %s", c.Name, name, strings.Join(allCollectors, ", "))
+		}
+	}
+	return nil
+}
+
+// constLabels returns the labels added to every metric of the cluster.
+func (c *ClusterConfig) constLabels() map[string]string {
+	labels := map[string]string{"cluster": c.Name}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func knownCollector(name string) bool {
+	for _, c := range allCollectors {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Module is a named set of settings used by /probe. The prober decides what
+// the target parameter names: "monitor" runs fdfs_monitor in the target pod,
+// container or host, "tracker" queries the target tracker address directly.
+type Module struct {
+	Prober        string `yaml:"prober"`
+	FastDFSConfig `yaml:",inline"`
+}
+
+func (m *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*m = Module{Prober: "monitor", FastDFSConfig: defaultConfig}
+	type plain Module
+	if err := unmarshal((*plain)(m)); err != nil {
+		return err
+	}
+	if m.Prober != "monitor" && m.Prober != "tracker" {
+		return fmt.Errorf("unknown prober %q, want monitor or tracker", m.Prober)
+	}
+	return nil
+}
+
+func loadConfigFile(path string) (*ConfigFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c ConfigFile
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, cluster := range c.Clusters {
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("cluster %s is defined twice", cluster.Name)
+		}
+		names[cluster.Name] = true
+		// Series of the same metric must all have the same label names.
+		if i > 0 && labelNames(cluster.Labels) != labelNames(c.Clusters[0].Labels) {
+			return nil, fmt.Errorf("cluster %s: all clusters must set the same labels", cluster.Name)
+		}
+	}
+	return &c, nil
+}
+
+func labelNames(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// loadConfig builds the exporters for /metrics and the /probe modules from
+// the config file. Without a file, or when it declares no clusters, /metrics
+// collects from the cluster configured through the environment, which is
+// also the default /probe module.
+func loadConfig(path string, defaultTarget *target) ([]*Exporter, map[string]probeModule, error) {
+	modules := map[string]probeModule{
+		"": {prober: "monitor", target: defaultTarget},
+	}
+	c := &ConfigFile{}
+	if path != "" {
+		var err error
+		if c, err = loadConfigFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	var exporters []*Exporter
+	for _, cluster := range c.Clusters {
+		t, err := newTarget(cluster.FastDFSConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		exporter, err := NewExporter(t, cluster.constLabels(), cluster.Collectors)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+		}
+		exporters = append(exporters, exporter)
+	}
+	if len(exporters) == 0 {
+		exporter, err := NewExporter(defaultTarget, nil, allCollectors)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	for name, m := range c.Modules {
+		t, err := newTarget(m.FastDFSConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("module %s: %v", name, err)
+		}
+		modules[name] = probeModule{prober: m.Prober, target: t}
+	}
+	return exporters, modules, nil
+}
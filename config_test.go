@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a config file into a new directory, which the
+// caller removes.
+func writeConfigFile(t *testing.T, content string) (dir, path string) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir, path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, path := writeConfigFile(t, `
+clusters:
+  - name: production
+    labels: {env: prod}
+    executor: local
+  - name: staging
+    labels: {env: staging}
+    collectors: [tracker, group]
+    executor: local
+modules:
+  tracker:
+    prober: tracker
+  hosts:
+    executor: ssh
+`)
+	defer os.RemoveAll(dir)
+
+	c, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(c.Clusters))
+	}
+	prod, staging := c.Clusters[0], c.Clusters[1]
+	if !reflect.DeepEqual(prod.Collectors, allCollectors) || !reflect.DeepEqual(staging.Collectors, []string{"tracker", "group"}) {
+		t.Errorf("got collectors %v and %v", prod.Collectors, staging.Collectors)
+	}
+	if want := map[string]string{"cluster": "production", "env": "prod"}; !reflect.DeepEqual(prod.constLabels(), want) {
+		t.Errorf("got labels %v, want %v", prod.constLabels(), want)
+	}
+	// Unset keys keep the defaults.
+	if prod.TrackerPort != defaultConfig.TrackerPort || prod.NameSpace != defaultConfig.NameSpace {
+		t.Errorf("got tracker port %q and namespace %q, want the defaults", prod.TrackerPort, prod.NameSpace)
+	}
+	if c.Modules["tracker"].Prober != "tracker" || c.Modules["hosts"].Prober != "monitor" {
+		t.Errorf("got modules %+v", c.Modules)
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name, content, err string
+	}{
+		{"duplicate name", `
+clusters:
+  - name: production
+  - name: production
+`, "defined twice"},
+		{"inconsistent labels", `
+clusters:
+  - name: production
+    labels: {env: prod}
+  - name: staging
+    labels: {tier: staging}
+`, "same labels"},
+		{"missing labels", `
+clusters:
+  - name: production
+    labels: {env: prod}
+  - name: staging
+`, "same labels"},
+		{"unknown collector", `
+clusters:
+  - name: production
+    collectors: [tracker, nginx]
+`, `unknown collector "nginx"`},
+		{"no name", `
+clusters:
+  - executor: local
+`, "without a name"},
+		{"cluster label", `
+clusters:
+  - name: production
+    labels: {cluster: other}
+`, "set from the name"},
+		{"label of the exporter", `
+clusters:
+  - name: production
+    labels: {group: a}
+`, "already used"},
+		{"unknown key", `
+clusters:
+  - name: production
+    tracker_server: 10.0.0.1:22122
+`, "tracker_server"},
+		{"unknown prober", `
+modules:
+  http:
+    prober: http
+`, `unknown prober "http"`},
+	} {
+		dir, path := writeConfigFile(t, c.content)
+		_, err := loadConfigFile(path)
+		os.RemoveAll(dir)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got error %v, want one mentioning %s", c.name, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// discovery.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/prometheus/common/log"
+)
+
+// podInfo is a running pod matched by a label selector.
+type podInfo struct {
+	name string
+	ip   string
+}
+
+// discoverPods lists the running pods in the target's namespace that match
+// selector, sorted by name. It is called on every scrape so rescheduled pods
+// are picked up without restarting the exporter.
+func (t *target) discoverPods(ctx context.Context, selector string) ([]podInfo, error) {
+	list, err := t.kube.ListPods(ctx, t.config.NameSpace, selector)
+	if err != nil {
+		return nil, err
+	}
+	var pods []podInfo
+	for _, p := range list {
+		if p.Phase != "Running" {
+			continue
+		}
+		pods = append(pods, podInfo{name: p.Name, ip: p.IP})
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].name < pods[j].name })
+	return pods, nil
+}
+
+// noPodsError is returned when a selector matches no running pod.
+type noPodsError struct {
+	selector string
+}
+
+func (e noPodsError) Error() string {
+	return fmt.Sprintf("no running pods match selector %q", e.selector)
+}
+
+// fastdfsPods returns the pods to run fdfs_monitor in, in the order they
+// should be tried.
+func (t *target) fastdfsPods(ctx context.Context) ([]string, error) {
+	if t.config.PodSelector == "" {
+		return []string{t.config.PodName}, nil
+	}
+	pods, err := t.discoverPods(ctx, t.config.PodSelector)
+	if err != nil {
+		log.Errorf("Discovering FastDFS pods with selector %q failed: %v", t.config.PodSelector, err)
+		return nil, err
+	}
+	if len(pods) == 0 {
+		log.Errorf("No running FastDFS pods match selector %q", t.config.PodSelector)
+		return nil, noPodsError{t.config.PodSelector}
+	}
+	names := make([]string, 0, len(pods))
+	for _, p := range pods {
+		names = append(names, p.name)
+	}
+	return names, nil
+}
+
+// trackerAddresses returns the configured tracker addresses followed by
+// those of the tracker pods matching the tracker selector, each address
+// once. The configured addresses are returned even if discovery fails.
+func (t *target) trackerAddresses(ctx context.Context) ([]string, error) {
+	addrs := appendUnique(nil, t.config.TrackerServers...)
+	if t.config.TrackerSelector == "" {
+		return addrs, nil
+	}
+	pods, err := t.discoverPods(ctx, t.config.TrackerSelector)
+	if err != nil {
+		log.Errorf("Discovering tracker pods with selector %q failed: %v", t.config.TrackerSelector, err)
+		return addrs, err
+	}
+	if len(pods) == 0 {
+		log.Errorf("No running tracker pods match selector %q", t.config.TrackerSelector)
+		return addrs, noPodsError{t.config.TrackerSelector}
+	}
+	for _, p := range pods {
+		if p.ip != "" {
+			addrs = appendUnique(addrs, net.JoinHostPort(p.ip, t.config.TrackerPort))
+		}
+	}
+	return addrs, nil
+}
+
+// appendUnique appends the elements of add that are not in list yet. A
+// tracker listed twice would be exported twice, which the registry rejects.
+func appendUnique(list []string, add ...string) []string {
+	for _, s := range add {
+		found := false
+		for _, l := range list {
+			if l == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ruanchen/fastdfs-exporter/kube"
+)
+
+// newPodServer returns an API server listing the given pod list JSON.
+func newPodServer(t *testing.T, pods string) (*httptest.Server, *kube.Client) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pods))
+	}))
+	client, err := kube.NewClient(&kube.Config{Host: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestTrackerAddressesOverlap(t *testing.T) {
+	server, client := newPodServer(t, `{"items": [
+		{"metadata": {"name": "tracker-0"}, "status": {"phase": "Running", "podIP": "10.0.0.10"}},
+		{"metadata": {"name": "tracker-1"}, "status": {"phase": "Running", "podIP": "10.0.0.11"}},
+		{"metadata": {"name": "tracker-2"}, "status": {"phase": "Pending", "podIP": "10.0.0.12"}}
+	]}`)
+	defer server.Close()
+	tg := &target{
+		config: FastDFSConfig{
+			TrackerServers:  []string{"10.0.0.11:22122", "tracker.example.com:22122", "10.0.0.11:22122"},
+			NameSpace:       "fastdfs",
+			TrackerSelector: "app=tracker",
+			TrackerPort:     "22122",
+		},
+		kube: client,
+	}
+
+	addrs, err := tg.trackerAddresses(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.11:22122", "tracker.example.com:22122", "10.0.0.10:22122"}
+	if !reflect.DeepEqual(addrs, want) {
+		t.Errorf("got addresses %q, want %q", addrs, want)
+	}
+}
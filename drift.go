@@ -0,0 +1,123 @@
+// drift.go
+package main
+
+import (
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hostLabels           = []string{"host"}
+	configNodeRegistered = newDesc(
+		prometheus.BuildFQName(namespace, "config", "node_registered"),
+		"Whether a storage server on the host listed in Node_Hosts is registered with the tracker.",
+		hostLabels, nil,
+	)
+	configNodeActive = newDesc(
+		prometheus.BuildFQName(namespace, "config", "node_active"),
+		"Whether every storage server on the host listed in Node_Hosts is ACTIVE.",
+		hostLabels, nil,
+	)
+	topologyExpected = newDesc(
+		prometheus.BuildFQName(namespace, "topology", "expected"),
+		"How many trackers, groups and storages FastDFS.json declares.",
+		[]string{"kind"}, nil,
+	)
+	topologyActual = newDesc(
+		prometheus.BuildFQName(namespace, "topology", "actual"),
+		"How many trackers, groups and storages the cluster has.",
+		[]string{"kind"}, nil,
+	)
+	topologyDrift = newDesc(
+		prometheus.BuildFQName(namespace, "topology", "drift"),
+		"Whether the cluster differs from FastDFS.json in its counts or in a host of Node_Hosts that is missing or not ACTIVE.",
+		nil, nil,
+	)
+)
+
+func describeDriftMetrics(ch chan<- *prometheus.Desc) {
+	ch <- configNodeRegistered
+	ch <- configNodeActive
+	ch <- topologyExpected
+	ch <- topologyActual
+	ch <- topologyDrift
+}
+
+// collectDriftMetrics compares FastDFS.json with the live cluster. Trackers
+// are only compared when the exporter knows all of them, see
+// actualTrackers.
+func collectDriftMetrics(ch chan<- prometheus.Metric, config *ConfigInfoJSON, cluster *Cluster, trackers []trackerScrape, allTrackers bool) {
+	trackerCount, trackersKnown := actualTrackers(config, trackers, allTrackers)
+	var drift float64
+	for _, c := range []struct {
+		kind             string
+		expected, actual int
+		known            bool
+	}{
+		{"trackers", config.Tracker_Server_Num, trackerCount, trackersKnown},
+		{"groups", config.Group_Num, len(cluster.Groups), true},
+		{"storages", config.Storage_Num, len(cluster.Storages()), true},
+	} {
+		ch <- prometheus.MustNewConstMetric(topologyExpected, prometheus.GaugeValue, float64(c.expected), c.kind)
+		if !c.known {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(topologyActual, prometheus.GaugeValue, float64(c.actual), c.kind)
+		if c.expected != c.actual {
+			drift = 1
+		}
+	}
+
+	for _, host := range config.Node_Hosts {
+		var registered, active float64
+		if storages := storagesOnHost(cluster, host); len(storages) > 0 {
+			registered, active = 1, 1
+			for _, s := range storages {
+				if s.Status != "ACTIVE" {
+					active = 0
+				}
+			}
+		}
+		if active == 0 {
+			drift = 1
+		}
+		ch <- prometheus.MustNewConstMetric(configNodeRegistered, prometheus.GaugeValue, registered, host)
+		ch <- prometheus.MustNewConstMetric(configNodeActive, prometheus.GaugeValue, active, host)
+	}
+	ch <- prometheus.MustNewConstMetric(topologyDrift, prometheus.GaugeValue, drift)
+}
+
+// actualTrackers returns how many trackers of the cluster answered, and
+// false when the exporter may not know every tracker. Neither the trackers
+// nor fdfs_monitor tell which trackers a cluster has: fdfs_monitor only
+// prints the server_count of its own storage.conf. So the trackers queried
+// are taken as all of them when they were discovered by selector (all is
+// set), or when at least as many were configured as FastDFS.json declares.
+func actualTrackers(config *ConfigInfoJSON, trackers []trackerScrape, all bool) (int, bool) {
+	if len(trackers) == 0 || !all && len(trackers) < config.Tracker_Server_Num {
+		return 0, false
+	}
+	n := 0
+	for _, t := range trackers {
+		if t.err == nil {
+			n++
+		}
+	}
+	return n, true
+}
+
+// storagesOnHost returns the storage servers whose IP or hostname is host.
+// Node_Hosts entries may carry a port, which is ignored.
+func storagesOnHost(cluster *Cluster, host string) []*Storage {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var storages []*Storage
+	for _, s := range cluster.Storages() {
+		if s.IP == host || (s.Hostname != "" && s.Hostname == host) {
+			storages = append(storages, s)
+		}
+	}
+	return storages
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectDriftMetricsTrackers(t *testing.T) {
+	config := &ConfigInfoJSON{Tracker_Server_Num: 2, Group_Num: 1, Storage_Num: 1}
+	cluster := &Cluster{Groups: []*Group{{Name: "group1", Storages: []*Storage{{ID: "s1", IP: "10.0.0.1", Status: "ACTIVE"}}}}}
+	answered := trackerScrape{addr: "10.0.0.10:22122"}
+	failed := trackerScrape{addr: "10.0.0.11:22122", err: errors.New("connection refused")}
+
+	for _, c := range []struct {
+		name     string
+		trackers []trackerScrape
+		all      bool
+		actual   map[string]float64
+		drift    float64
+	}{
+		// fdfs_monitor only prints the trackers of its own storage.conf.
+		{"monitor", nil, false, map[string]float64{"kind=groups": 1, "kind=storages": 1}, 0},
+		{"subset configured", []trackerScrape{answered}, false, map[string]float64{"kind=groups": 1, "kind=storages": 1}, 0},
+		{"all configured", []trackerScrape{answered, failed}, false, map[string]float64{"kind=trackers": 1, "kind=groups": 1, "kind=storages": 1}, 1},
+		{"one discovered", []trackerScrape{answered}, true, map[string]float64{"kind=trackers": 1, "kind=groups": 1, "kind=storages": 1}, 1},
+	} {
+		metrics := gatherFunc(func(ch chan<- prometheus.Metric) {
+			collectDriftMetrics(ch, config, cluster, c.trackers, c.all)
+		})
+		if got := values(t, metrics, topologyActual); !reflect.DeepEqual(got, c.actual) {
+			t.Errorf("%s: got actual %v, want %v", c.name, got, c.actual)
+		}
+		if got := values(t, metrics, topologyExpected); len(got) != 3 || got["kind=trackers"] != 2 {
+			t.Errorf("%s: got expected %v, want all three kinds with 2 trackers", c.name, got)
+		}
+		if drift, _ := gaugeValue(t, metrics, topologyDrift); drift != c.drift {
+			t.Errorf("%s: drift = %v, want %v", c.name, drift, c.drift)
+		}
+	}
+}
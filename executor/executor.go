@@ -0,0 +1,150 @@
+// Package executor runs commands on the hosts, containers or pods that hold
+// a FastDFS installation.
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ruanchen/fastdfs-exporter/kube"
+)
+
+// Executor runs a command on a target and returns what it wrote to stdout.
+// What a target names depends on the implementation: a pod, a container, a
+// host, or nothing at all for Local. When ctx is done the command is
+// stopped and ctx.Err() returned.
+type Executor interface {
+	Run(ctx context.Context, target string, command ...string) ([]byte, error)
+}
+
+// Local runs commands as child processes of the exporter.
+type Local struct{}
+
+// Run ignores target.
+func (Local) Run(ctx context.Context, target string, command ...string) ([]byte, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("executor: empty command")
+	}
+	return run(ctx, command[0], command[1:]...)
+}
+
+// Docker runs commands in containers through the docker CLI, which honors
+// DOCKER_HOST and the other docker environment variables.
+type Docker struct{}
+
+// Run executes command in the container named target.
+func (Docker) Run(ctx context.Context, target string, command ...string) ([]byte, error) {
+	if err := checkTarget(target); err != nil {
+		return nil, err
+	}
+	return run(ctx, "docker", append([]string{"exec", target}, command...)...)
+}
+
+// SSH runs commands on remote hosts through the ssh client. Authentication
+// must not be interactive.
+type SSH struct {
+	User         string
+	Port         int
+	IdentityFile string
+}
+
+// Run executes command on the host named target.
+func (s SSH) Run(ctx context.Context, target string, command ...string) ([]byte, error) {
+	if err := checkTarget(target); err != nil {
+		return nil, err
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if s.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(s.Port))
+	}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile)
+	}
+	if s.User != "" {
+		target = s.User + "@" + target
+	}
+	// ssh hands the remote shell a single string, so quote every word.
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = shellQuote(arg)
+	}
+	// ssh reads options after the host too, so end them before it.
+	args = append(args, "--", target, strings.Join(quoted, " "))
+	return run(ctx, "ssh", args...)
+}
+
+// checkTarget refuses targets the docker and ssh clients would take for an
+// option.
+func checkTarget(target string) error {
+	if target == "" || strings.HasPrefix(target, "-") {
+		return fmt.Errorf("executor: invalid target %q", target)
+	}
+	return nil
+}
+
+// Kubernetes runs commands in pods through the API server's exec endpoint.
+type Kubernetes struct {
+	Client    *kube.Client
+	Namespace string
+	Container string
+}
+
+// Run executes command in the pod named target.
+func (k Kubernetes) Run(ctx context.Context, target string, command ...string) ([]byte, error) {
+	return k.Client.Exec(ctx, k.Namespace, target, k.Container, command)
+}
+
+// CommandError is returned when a child process fails. Stderr is what the
+// command wrote there, which is often the only hint at what went wrong.
+type CommandError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("%v: %s", e.Err, e.Stderr)
+	}
+	return e.Err.Error()
+}
+
+// run runs a child process, killing it and everything it started when ctx
+// is done.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, &CommandError{Err: err}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if err != nil {
+		if ctx.Err() != nil {
+			return stdout.Bytes(), ctx.Err()
+		}
+		return stdout.Bytes(), &CommandError{Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return stdout.Bytes(), nil
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,@") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
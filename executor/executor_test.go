@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeBinary puts a script named name on PATH that prints its arguments one
+// per line, and returns a function restoring PATH.
+func fakeBinary(t *testing.T, name string) func() {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\nfor arg; do echo \"$arg\"; done\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSSHRunEndsOptionsBeforeHost(t *testing.T) {
+	defer fakeBinary(t, "ssh")()
+	out, err := SSH{User: "fdfs", Port: 2222}.Run(context.Background(), "storage-0", "cat", "/etc/fdfs/a b.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-o\nBatchMode=yes\n-p\n2222\n--\nfdfs@storage-0\ncat '/etc/fdfs/a b.conf'\n"
+	if string(out) != want {
+		t.Errorf("ssh got arguments\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestRunRefusesOptionTargets(t *testing.T) {
+	defer fakeBinary(t, "ssh")()
+	defer fakeBinary(t, "docker")()
+	for name, e := range map[string]Executor{"ssh": SSH{}, "docker": Docker{}} {
+		for _, target := range []string{"-oProxyCommand=touch /tmp/pwned", "--privileged", ""} {
+			out, err := e.Run(context.Background(), target, "true")
+			if err == nil {
+				t.Errorf("%s ran with target %q: %s", name, target, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+}
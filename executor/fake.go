@@ -0,0 +1,62 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Fake answers commands from canned responses, so code built on an Executor
+// can be tested without a FastDFS installation. It is safe for concurrent
+// use.
+type Fake struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	calls     []string
+}
+
+type fakeResponse struct {
+	stdout []byte
+	err    error
+}
+
+// NewFake returns a Fake without any responses.
+func NewFake() *Fake {
+	return &Fake{responses: map[string]fakeResponse{}}
+}
+
+// Set makes Run return stdout and err for command on target.
+func (f *Fake) Set(target string, command []string, stdout []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[fakeKey(target, command)] = fakeResponse{stdout: stdout, err: err}
+}
+
+// Calls returns every target and command Run was called with, in order.
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+// Run returns the response registered for command on target, or ctx.Err()
+// if ctx is already done.
+func (f *Fake) Run(ctx context.Context, target string, command ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := fakeKey(target, command)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, key)
+	r, ok := f.responses[key]
+	if !ok {
+		return nil, fmt.Errorf("executor: no fake response for %q", key)
+	}
+	return r.stdout, r.err
+}
+
+func fakeKey(target string, command []string) string {
+	return target + ": " + strings.Join(command, " ")
+}
@@ -0,0 +1,101 @@
+package fdfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// conn is a connection to a tracker or storage server. Both speak the same
+// framing: one header and body per request, answered by one header and body.
+type conn struct {
+	net.Conn
+	timeout time.Duration
+	done    chan struct{}
+}
+
+// dial connects to addr. The timeout bounds the dial and every subsequent
+// request.
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, timeout: timeout}, nil
+}
+
+// dialContext connects to addr. The deadline of ctx bounds the dial and
+// every subsequent request, and the connection is closed as soon as ctx is
+// done.
+func dialContext(ctx context.Context, addr string) (*conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := nc.SetDeadline(deadline); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	c := &conn{Conn: nc, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-c.done:
+		}
+	}()
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	if c.done != nil {
+		close(c.done)
+	}
+	return c.Conn.Close()
+}
+
+// call sends one request made of the concatenated parts and returns the
+// body of the response.
+func (c *conn) call(cmd byte, parts ...[]byte) ([]byte, error) {
+	if c.timeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+	var length int
+	for _, p := range parts {
+		length += len(p)
+	}
+	if err := WriteHeader(c, Header{Length: int64(length), Cmd: cmd}); err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		if _, err := c.Write(p); err != nil {
+			return nil, err
+		}
+	}
+	h, err := ReadHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	if h.Cmd != TrackerProtoCmdResp {
+		return nil, fmt.Errorf("fdfs: unexpected response command %d", h.Cmd)
+	}
+	if h.Length < 0 {
+		return nil, fmt.Errorf("fdfs: invalid response length %d", h.Length)
+	}
+	body := make([]byte, h.Length)
+	if _, err := io.ReadFull(c, body); err != nil {
+		return nil, err
+	}
+	if h.Status != 0 {
+		return nil, &StatusError{Cmd: cmd, Status: h.Status}
+	}
+	return body, nil
+}
@@ -0,0 +1,4 @@
+package fdfs
+
+// StoreServerSize exposes storeServerSize to the external tests.
+const StoreServerSize = storeServerSize
@@ -0,0 +1,138 @@
+package fdfstest
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+// Statuses a server answers with for unknown groups, files and commands.
+const (
+	errnoNotFound = 2  // ENOENT
+	errnoInvalid  = 22 // EINVAL
+)
+
+// server accepts connections on a loopback port and answers every request
+// with respond.
+type server struct {
+	Addr string
+
+	listener net.Listener
+	respond  func(cmd byte, req []byte) ([]byte, byte)
+	wg       sync.WaitGroup
+	closed   chan struct{}
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	requests int
+	held     chan struct{}
+}
+
+func (s *server) start(respond func(cmd byte, req []byte) ([]byte, byte)) error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.Addr = l.Addr().String()
+	s.listener = l
+	s.respond = respond
+	s.conns = map[net.Conn]struct{}{}
+	s.closed = make(chan struct{})
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Requests returns how many requests the server has received.
+func (s *server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Hold keeps the server from answering until the returned function is
+// called. Requests received in the meantime are answered then.
+func (s *server) Hold() (release func()) {
+	held := make(chan struct{})
+	s.mu.Lock()
+	s.held = held
+	s.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.held = nil
+			s.mu.Unlock()
+			close(held)
+		})
+	}
+}
+
+// Close stops the server, closes its open connections and waits for their
+// handlers to return.
+func (s *server) Close() error {
+	err := s.listener.Close()
+	close(s.closed)
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			conn.Close()
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *server) handle(conn net.Conn) {
+	for {
+		h, err := fdfs.ReadHeader(conn)
+		if err != nil {
+			return
+		}
+		req := make([]byte, h.Length)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.requests++
+		held := s.held
+		s.mu.Unlock()
+		if held != nil {
+			select {
+			case <-held:
+			case <-s.closed:
+				return
+			}
+		}
+		body, status := s.respond(h.Cmd, req)
+		if err := fdfs.WriteHeader(conn, fdfs.Header{Length: int64(len(body)), Cmd: fdfs.TrackerProtoCmdResp, Status: status}); err != nil {
+			return
+		}
+		if _, err := conn.Write(body); err != nil {
+			return
+		}
+	}
+}
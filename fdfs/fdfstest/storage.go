@@ -0,0 +1,223 @@
+package fdfstest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+// Storage is a fake storage server of one group that keeps uploaded files
+// in memory.
+type Storage struct {
+	server
+	group string
+
+	mu       sync.Mutex
+	files    map[string]file
+	next     int
+	failures map[byte]byte
+	corrupt  bool
+	peers    []*Storage
+	delay    time.Duration
+}
+
+// file is a stored file together with what QUERY_FILE_INFO reports on it.
+type file struct {
+	data    []byte
+	created int64
+	source  string
+}
+
+// NewStorage starts a fake storage server of group. Callers should Close it
+// when done.
+func NewStorage(group string) (*Storage, error) {
+	s := &Storage{
+		group:    group,
+		files:    map[string]file{},
+		failures: map[byte]byte{},
+	}
+	if err := s.start(s.respond); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stat returns an ACTIVE storage stat pointing at the server, for
+// registering it with a fake Tracker.
+func (s *Storage) Stat(id string) fdfs.StorageStat {
+	host, port, _ := net.SplitHostPort(s.Addr)
+	p, _ := strconv.ParseInt(port, 10, 64)
+	return fdfs.StorageStat{
+		Status:      fdfs.StorageStatusActive,
+		ID:          id,
+		IPAddr:      host,
+		StoragePort: p,
+	}
+}
+
+// Files returns a copy of the files currently stored.
+func (s *Storage) Files() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string][]byte, len(s.files))
+	for name, f := range s.files {
+		files[name] = append([]byte(nil), f.data...)
+	}
+	return files
+}
+
+// Replicate makes the server copy uploads and deletes to peers, the other
+// members of its group, after delay.
+func (s *Storage) Replicate(delay time.Duration, peers ...*Storage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers, s.delay = peers, delay
+}
+
+// sync applies an upload, or a delete when f is nil, to the peers once the
+// replication delay has passed.
+func (s *Storage) sync(name string, f *file) {
+	for _, peer := range s.peers {
+		peer := peer
+		time.AfterFunc(s.delay, func() {
+			peer.mu.Lock()
+			defer peer.mu.Unlock()
+			if f == nil {
+				delete(peer.files, name)
+			} else {
+				peer.files[name] = *f
+			}
+		})
+	}
+}
+
+// Fail makes the server answer cmd with status. A zero status restores the
+// normal answer.
+func (s *Storage) Fail(cmd, status byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, cmd)
+		return
+	}
+	s.failures[cmd] = status
+}
+
+// Corrupt makes downloads return content that differs from what was
+// uploaded.
+func (s *Storage) Corrupt(corrupt bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corrupt = corrupt
+}
+
+// ServeHTTP serves the stored files under /group/remote_filename, the way
+// the nginx module does. Serve it with httptest and register its port as the
+// StorageHTTPPort of the storage.
+func (s *Storage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	f, ok := s.files[strings.TrimPrefix(path, s.group+"/")]
+	if !ok || !strings.HasPrefix(path, s.group+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	data := f.data
+	if s.corrupt && len(data) > 0 {
+		data = append([]byte{data[0] ^ 0xff}, data[1:]...)
+	}
+	w.Write(data)
+}
+
+func (s *Storage) respond(cmd byte, req []byte) ([]byte, byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status, ok := s.failures[cmd]; ok {
+		return nil, status
+	}
+	switch cmd {
+	case fdfs.FdfsProtoCmdActiveTest:
+		return nil, 0
+	case fdfs.StorageProtoCmdUploadFile:
+		const head = 1 + 8 + fdfs.FileExtNameMaxLen
+		if len(req) < head || binary.BigEndian.Uint64(req[1:9]) != uint64(len(req)-head) {
+			return nil, errnoInvalid
+		}
+		ext := string(bytes.TrimRight(req[9:head], "\x00"))
+		name := fmt.Sprintf("M%02X/00/00/%08d", req[0], s.next)
+		if ext != "" {
+			name += "." + ext
+		}
+		s.next++
+		host, _, _ := net.SplitHostPort(s.Addr)
+		f := file{data: append([]byte(nil), req[head:]...), created: time.Now().Unix(), source: host}
+		s.files[name] = f
+		s.sync(name, &f)
+		return append(fixedString(s.group, fdfs.GroupNameMaxLen), name...), 0
+	case fdfs.StorageProtoCmdDownloadFile:
+		if len(req) < 16 {
+			return nil, errnoInvalid
+		}
+		offset := binary.BigEndian.Uint64(req[:8])
+		length := binary.BigEndian.Uint64(req[8:16])
+		f, ok := s.file(req[16:])
+		if !ok {
+			return nil, errnoNotFound
+		}
+		data := f.data
+		if offset > uint64(len(data)) {
+			return nil, errnoInvalid
+		}
+		data = data[offset:]
+		if length > 0 && length < uint64(len(data)) {
+			data = data[:length]
+		}
+		data = append([]byte(nil), data...)
+		if s.corrupt && len(data) > 0 {
+			data[0] ^= 0xff
+		}
+		return data, 0
+	case fdfs.StorageProtoCmdDeleteFile:
+		if _, ok := s.file(req); !ok {
+			return nil, errnoNotFound
+		}
+		name := string(req[fdfs.GroupNameMaxLen:])
+		delete(s.files, name)
+		s.sync(name, nil)
+		return nil, 0
+	case fdfs.StorageProtoCmdQueryFileInfo:
+		f, ok := s.file(req)
+		if !ok {
+			return nil, errnoNotFound
+		}
+		body := make([]byte, 3*8, 3*8+fdfs.IPAddressSize)
+		binary.BigEndian.PutUint64(body[0:8], uint64(len(f.data)))
+		binary.BigEndian.PutUint64(body[8:16], uint64(f.created))
+		binary.BigEndian.PutUint64(body[16:24], uint64(crc32.ChecksumIEEE(f.data)))
+		return append(body, fixedString(f.source, fdfs.IPAddressSize)...), 0
+	default:
+		return nil, errnoInvalid
+	}
+}
+
+// file returns the file named by a group field followed by a file name.
+func (s *Storage) file(req []byte) (file, bool) {
+	if len(req) < fdfs.GroupNameMaxLen {
+		return file{}, false
+	}
+	if string(bytes.TrimRight(req[:fdfs.GroupNameMaxLen], "\x00")) != s.group {
+		return file{}, false
+	}
+	f, ok := s.files[string(req[fdfs.GroupNameMaxLen:])]
+	return f, ok
+}
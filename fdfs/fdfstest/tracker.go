@@ -0,0 +1,148 @@
+// Package fdfstest provides in-process fake FastDFS trackers and storage
+// servers for tests.
+package fdfstest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+// Tracker is a fake tracker listening on a loopback port. Its groups and
+// storages can be changed while it is serving.
+type Tracker struct {
+	server
+
+	mu       sync.Mutex
+	status   fdfs.TrackerStatus
+	groups   []fdfs.GroupStat
+	storages map[string][]fdfs.StorageStat
+}
+
+// NewTracker starts a fake tracker. Callers should Close it when done.
+func NewTracker() (*Tracker, error) {
+	t := &Tracker{storages: map[string][]fdfs.StorageStat{}}
+	if err := t.start(t.respond); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetStatus sets the answer to TRACKER_GET_STATUS.
+func (t *Tracker) SetStatus(status fdfs.TrackerStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status = status
+}
+
+// AddGroup registers a group together with its storage servers.
+func (t *Tracker) AddGroup(group fdfs.GroupStat, storages ...fdfs.StorageStat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.groups = append(t.groups, group)
+	t.storages[group.Name] = storages
+}
+
+// RemoveGroup unregisters the group and its storage servers.
+func (t *Tracker) RemoveGroup(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, g := range t.groups {
+		if g.Name == name {
+			t.groups = append(t.groups[:i], t.groups[i+1:]...)
+			break
+		}
+	}
+	delete(t.storages, name)
+}
+
+func (t *Tracker) respond(cmd byte, req []byte) ([]byte, byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var body []byte
+	switch cmd {
+	case fdfs.TrackerProtoCmdTrackerGetStatus:
+		body, _ = t.status.MarshalBinary()
+	case fdfs.TrackerProtoCmdServerListAllGroups:
+		for i := range t.groups {
+			b, _ := t.groups[i].MarshalBinary()
+			body = append(body, b...)
+		}
+	case fdfs.TrackerProtoCmdServerListOneGroup:
+		g := t.group(req)
+		if g == nil {
+			return nil, errnoNotFound
+		}
+		body, _ = g.MarshalBinary()
+	case fdfs.TrackerProtoCmdServerListStorage:
+		g := t.group(req)
+		if g == nil {
+			return nil, errnoNotFound
+		}
+		id := ""
+		if len(req) > fdfs.GroupNameMaxLen {
+			id = string(req[fdfs.GroupNameMaxLen:])
+		}
+		for i := range t.storages[g.Name] {
+			s := &t.storages[g.Name][i]
+			if id != "" && s.ID != id {
+				continue
+			}
+			b, _ := s.MarshalBinary()
+			body = append(body, b...)
+		}
+	case fdfs.TrackerProtoCmdServiceQueryStoreWithoutGroupOne, fdfs.TrackerProtoCmdServiceQueryStoreWithGroupOne:
+		g := t.group(req)
+		if cmd == fdfs.TrackerProtoCmdServiceQueryStoreWithoutGroupOne && len(t.groups) > 0 {
+			g = &t.groups[0]
+		}
+		if g == nil {
+			return nil, errnoNotFound
+		}
+		s := t.store(g.Name)
+		if s == nil {
+			return nil, errnoNotFound
+		}
+		body = append(fixedString(g.Name, fdfs.GroupNameMaxLen), fixedString(s.IPAddr, fdfs.IPAddressSize-1)...)
+		var port [8]byte
+		binary.BigEndian.PutUint64(port[:], uint64(s.StoragePort))
+		body = append(body, port[:]...)
+		body = append(body, 0)
+	default:
+		return nil, errnoInvalid
+	}
+	return body, 0
+}
+
+// store returns the first ACTIVE storage server of group.
+func (t *Tracker) store(group string) *fdfs.StorageStat {
+	for i := range t.storages[group] {
+		if t.storages[group][i].Status == fdfs.StorageStatusActive {
+			return &t.storages[group][i]
+		}
+	}
+	return nil
+}
+
+// fixedString returns s as a zero padded field of the given width.
+func fixedString(s string, width int) []byte {
+	buf := make([]byte, width)
+	copy(buf, s)
+	return buf
+}
+
+// group returns the group named in the first GroupNameMaxLen bytes of req.
+func (t *Tracker) group(req []byte) *fdfs.GroupStat {
+	if len(req) < fdfs.GroupNameMaxLen {
+		return nil
+	}
+	name := string(bytes.TrimRight(req[:fdfs.GroupNameMaxLen], "\x00"))
+	for i := range t.groups {
+		if t.groups[i].Name == name {
+			return &t.groups[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+// Package fdfs implements the FastDFS tracker and storage binary protocol.
+package fdfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Protocol commands.
+const (
+	StorageProtoCmdUploadFile                       = 11
+	StorageProtoCmdDeleteFile                       = 12
+	StorageProtoCmdDownloadFile                     = 14
+	StorageProtoCmdQueryFileInfo                    = 22
+	TrackerProtoCmdTrackerGetStatus                 = 64
+	TrackerProtoCmdServerListOneGroup               = 90
+	TrackerProtoCmdServerListAllGroups              = 91
+	TrackerProtoCmdServerListStorage                = 92
+	TrackerProtoCmdResp                             = 100
+	TrackerProtoCmdServiceQueryStoreWithoutGroupOne = 101
+	TrackerProtoCmdServiceQueryStoreWithGroupOne    = 104
+	FdfsProtoCmdActiveTest                          = 111
+)
+
+// Sizes of the fixed-width fields used on the wire.
+const (
+	HeaderSize        = 10
+	GroupNameMaxLen   = 16
+	StorageIDMaxSize  = 16
+	IPAddressSize     = 16
+	DomainNameMaxSize = 128
+	VersionSize       = 6
+	FileExtNameMaxLen = 6
+)
+
+// StorageStatus is the state of a storage server as kept by the tracker.
+type StorageStatus byte
+
+// Storage server states.
+const (
+	StorageStatusInit      StorageStatus = 0
+	StorageStatusWaitSync  StorageStatus = 1
+	StorageStatusSyncing   StorageStatus = 2
+	StorageStatusIPChanged StorageStatus = 3
+	StorageStatusDeleted   StorageStatus = 4
+	StorageStatusOffline   StorageStatus = 5
+	StorageStatusOnline    StorageStatus = 6
+	StorageStatusActive    StorageStatus = 7
+	StorageStatusRecovery  StorageStatus = 9
+	StorageStatusNone      StorageStatus = 99
+)
+
+var storageStatusNames = map[StorageStatus]string{
+	StorageStatusInit:      "INIT",
+	StorageStatusWaitSync:  "WAIT_SYNC",
+	StorageStatusSyncing:   "SYNCING",
+	StorageStatusIPChanged: "IP_CHANGED",
+	StorageStatusDeleted:   "DELETED",
+	StorageStatusOffline:   "OFFLINE",
+	StorageStatusOnline:    "ONLINE",
+	StorageStatusActive:    "ACTIVE",
+	StorageStatusRecovery:  "RECOVERY",
+	StorageStatusNone:      "NONE",
+}
+
+// String returns the name fdfs_monitor prints for the status.
+func (s StorageStatus) String() string {
+	if name, ok := storageStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", byte(s))
+}
+
+// Header precedes every request and response: an 8 byte big-endian body
+// length, the command and a status byte that carries an errno in responses.
+type Header struct {
+	Length int64
+	Cmd    byte
+	Status byte
+}
+
+// StatusError is returned when a server answers with a non-zero status.
+type StatusError struct {
+	Cmd    byte
+	Status byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fdfs: command %d failed with status %d", e.Cmd, e.Status)
+}
+
+// ReadHeader reads a protocol header from r.
+func ReadHeader(r io.Reader) (Header, error) {
+	var buf [HeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return Header{}, err
+	}
+	return Header{
+		Length: int64(binary.BigEndian.Uint64(buf[:8])),
+		Cmd:    buf[8],
+		Status: buf[9],
+	}, nil
+}
+
+// WriteHeader writes a protocol header to w.
+func WriteHeader(w io.Writer, h Header) error {
+	var buf [HeaderSize]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(h.Length))
+	buf[8] = h.Cmd
+	buf[9] = h.Status
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// fixedString returns s as a zero padded field of the given width.
+func fixedString(s string, width int) []byte {
+	buf := make([]byte, width)
+	copy(buf, s)
+	return buf
+}
+
+// cString returns the zero terminated string held in a fixed width field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// TrackerStatus is the answer to TRACKER_GET_STATUS. Durations are seconds.
+type TrackerStatus struct {
+	IfLeader        bool
+	RunningTime     int64
+	RestartInterval int64
+}
+
+type trackerStatusWire struct {
+	IfLeader        byte
+	RunningTime     int64
+	RestartInterval int64
+}
+
+// TrackerStatusSize is the wire size of a TrackerStatus.
+var TrackerStatusSize = binary.Size(trackerStatusWire{})
+
+// MarshalBinary encodes s as sent by the tracker.
+func (s *TrackerStatus) MarshalBinary() ([]byte, error) {
+	w := trackerStatusWire{
+		RunningTime:     s.RunningTime,
+		RestartInterval: s.RestartInterval,
+	}
+	if s.IfLeader {
+		w.IfLeader = 1
+	}
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.BigEndian, &w)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a TrackerStatus as sent by the tracker.
+func (s *TrackerStatus) UnmarshalBinary(data []byte) error {
+	if len(data) != TrackerStatusSize {
+		return fmt.Errorf("fdfs: tracker status is %d bytes, want %d", len(data), TrackerStatusSize)
+	}
+	var w trackerStatusWire
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &w); err != nil {
+		return err
+	}
+	*s = TrackerStatus{
+		IfLeader:        w.IfLeader != 0,
+		RunningTime:     w.RunningTime,
+		RestartInterval: w.RestartInterval,
+	}
+	return nil
+}
+
+// GroupStat is the per-group record returned by the LIST_*_GROUP commands.
+type GroupStat struct {
+	Name               string
+	TotalMB            int64
+	FreeMB             int64
+	TrunkFreeMB        int64
+	StorageCount       int64
+	StoragePort        int64
+	StorageHTTPPort    int64
+	ActiveCount        int64
+	CurrentWriteServer int64
+	StorePathCount     int64
+	SubdirCountPerPath int64
+	CurrentTrunkFileID int64
+}
+
+type groupStatWire struct {
+	Name               [GroupNameMaxLen + 1]byte
+	TotalMB            int64
+	FreeMB             int64
+	TrunkFreeMB        int64
+	StorageCount       int64
+	StoragePort        int64
+	StorageHTTPPort    int64
+	ActiveCount        int64
+	CurrentWriteServer int64
+	StorePathCount     int64
+	SubdirCountPerPath int64
+	CurrentTrunkFileID int64
+}
+
+// GroupStatSize is the wire size of a GroupStat.
+var GroupStatSize = binary.Size(groupStatWire{})
+
+// MarshalBinary encodes g as sent by the tracker.
+func (g *GroupStat) MarshalBinary() ([]byte, error) {
+	w := groupStatWire{
+		TotalMB:            g.TotalMB,
+		FreeMB:             g.FreeMB,
+		TrunkFreeMB:        g.TrunkFreeMB,
+		StorageCount:       g.StorageCount,
+		StoragePort:        g.StoragePort,
+		StorageHTTPPort:    g.StorageHTTPPort,
+		ActiveCount:        g.ActiveCount,
+		CurrentWriteServer: g.CurrentWriteServer,
+		StorePathCount:     g.StorePathCount,
+		SubdirCountPerPath: g.SubdirCountPerPath,
+		CurrentTrunkFileID: g.CurrentTrunkFileID,
+	}
+	copy(w.Name[:], g.Name)
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.BigEndian, &w)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a GroupStat as sent by the tracker.
+func (g *GroupStat) UnmarshalBinary(data []byte) error {
+	if len(data) != GroupStatSize {
+		return fmt.Errorf("fdfs: group stat is %d bytes, want %d", len(data), GroupStatSize)
+	}
+	var w groupStatWire
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &w); err != nil {
+		return err
+	}
+	*g = GroupStat{
+		Name:               cString(w.Name[:]),
+		TotalMB:            w.TotalMB,
+		FreeMB:             w.FreeMB,
+		TrunkFreeMB:        w.TrunkFreeMB,
+		StorageCount:       w.StorageCount,
+		StoragePort:        w.StoragePort,
+		StorageHTTPPort:    w.StorageHTTPPort,
+		ActiveCount:        w.ActiveCount,
+		CurrentWriteServer: w.CurrentWriteServer,
+		StorePathCount:     w.StorePathCount,
+		SubdirCountPerPath: w.SubdirCountPerPath,
+		CurrentTrunkFileID: w.CurrentTrunkFileID,
+	}
+	return nil
+}
+
+// StorageCounters are the statistics a storage server reports to the
+// tracker. Timestamps are Unix seconds.
+type StorageCounters struct {
+	ConnectionAllocCount   int32
+	ConnectionCurrentCount int32
+	ConnectionMaxCount     int32
+	TotalUploadCount       int64
+	SuccessUploadCount     int64
+	TotalAppendCount       int64
+	SuccessAppendCount     int64
+	TotalModifyCount       int64
+	SuccessModifyCount     int64
+	TotalTruncateCount     int64
+	SuccessTruncateCount   int64
+	TotalSetMetaCount      int64
+	SuccessSetMetaCount    int64
+	TotalDeleteCount       int64
+	SuccessDeleteCount     int64
+	TotalDownloadCount     int64
+	SuccessDownloadCount   int64
+	TotalGetMetaCount      int64
+	SuccessGetMetaCount    int64
+	TotalCreateLinkCount   int64
+	SuccessCreateLinkCount int64
+	TotalDeleteLinkCount   int64
+	SuccessDeleteLinkCount int64
+	TotalUploadBytes       int64
+	SuccessUploadBytes     int64
+	TotalAppendBytes       int64
+	SuccessAppendBytes     int64
+	TotalModifyBytes       int64
+	SuccessModifyBytes     int64
+	TotalDownloadBytes     int64
+	SuccessDownloadBytes   int64
+	TotalSyncInBytes       int64
+	SuccessSyncInBytes     int64
+	TotalSyncOutBytes      int64
+	SuccessSyncOutBytes    int64
+	TotalFileOpenCount     int64
+	SuccessFileOpenCount   int64
+	TotalFileReadCount     int64
+	SuccessFileReadCount   int64
+	TotalFileWriteCount    int64
+	SuccessFileWriteCount  int64
+	LastSourceUpdate       int64
+	LastSyncUpdate         int64
+	LastSyncedTimestamp    int64
+	LastHeartBeatTime      int64
+}
+
+// StorageStat is the per-storage record returned by LIST_STORAGE.
+// JoinTime and UpTime are Unix seconds.
+type StorageStat struct {
+	Status             StorageStatus
+	ID                 string
+	IPAddr             string
+	DomainName         string
+	SrcID              string
+	Version            string
+	JoinTime           int64
+	UpTime             int64
+	TotalMB            int64
+	FreeMB             int64
+	UploadPriority     int64
+	StorePathCount     int64
+	SubdirCountPerPath int64
+	StoragePort        int64
+	StorageHTTPPort    int64
+	CurrentWritePath   int64
+	IfTrunkServer      bool
+	StorageCounters
+}
+
+type storageStatWire struct {
+	Status             byte
+	ID                 [StorageIDMaxSize]byte
+	IPAddr             [IPAddressSize]byte
+	DomainName         [DomainNameMaxSize]byte
+	SrcID              [StorageIDMaxSize]byte
+	Version            [VersionSize]byte
+	JoinTime           int64
+	UpTime             int64
+	TotalMB            int64
+	FreeMB             int64
+	UploadPriority     int64
+	StorePathCount     int64
+	SubdirCountPerPath int64
+	StoragePort        int64
+	StorageHTTPPort    int64
+	CurrentWritePath   int64
+	IfTrunkServer      byte
+	Counters           StorageCounters
+}
+
+// StorageStatSize is the wire size of a StorageStat.
+var StorageStatSize = binary.Size(storageStatWire{})
+
+// MarshalBinary encodes s as sent by the tracker.
+func (s *StorageStat) MarshalBinary() ([]byte, error) {
+	w := storageStatWire{
+		Status:             byte(s.Status),
+		JoinTime:           s.JoinTime,
+		UpTime:             s.UpTime,
+		TotalMB:            s.TotalMB,
+		FreeMB:             s.FreeMB,
+		UploadPriority:     s.UploadPriority,
+		StorePathCount:     s.StorePathCount,
+		SubdirCountPerPath: s.SubdirCountPerPath,
+		StoragePort:        s.StoragePort,
+		StorageHTTPPort:    s.StorageHTTPPort,
+		CurrentWritePath:   s.CurrentWritePath,
+		Counters:           s.StorageCounters,
+	}
+	copy(w.ID[:], s.ID)
+	copy(w.IPAddr[:], s.IPAddr)
+	copy(w.DomainName[:], s.DomainName)
+	copy(w.SrcID[:], s.SrcID)
+	copy(w.Version[:], s.Version)
+	if s.IfTrunkServer {
+		w.IfTrunkServer = 1
+	}
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.BigEndian, &w)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a StorageStat as sent by the tracker.
+func (s *StorageStat) UnmarshalBinary(data []byte) error {
+	if len(data) != StorageStatSize {
+		return fmt.Errorf("fdfs: storage stat is %d bytes, want %d", len(data), StorageStatSize)
+	}
+	var w storageStatWire
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &w); err != nil {
+		return err
+	}
+	*s = StorageStat{
+		Status:             StorageStatus(w.Status),
+		ID:                 cString(w.ID[:]),
+		IPAddr:             cString(w.IPAddr[:]),
+		DomainName:         cString(w.DomainName[:]),
+		SrcID:              cString(w.SrcID[:]),
+		Version:            cString(w.Version[:]),
+		JoinTime:           w.JoinTime,
+		UpTime:             w.UpTime,
+		TotalMB:            w.TotalMB,
+		FreeMB:             w.FreeMB,
+		UploadPriority:     w.UploadPriority,
+		StorePathCount:     w.StorePathCount,
+		SubdirCountPerPath: w.SubdirCountPerPath,
+		StoragePort:        w.StoragePort,
+		StorageHTTPPort:    w.StorageHTTPPort,
+		CurrentWritePath:   w.CurrentWritePath,
+		IfTrunkServer:      w.IfTrunkServer != 0,
+		StorageCounters:    w.Counters,
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package fdfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Storage is a connection to a FastDFS storage server. It is not safe for
+// concurrent use.
+type Storage struct {
+	conn *conn
+}
+
+// DialStorage connects to the storage server at addr. The timeout bounds
+// the dial and every subsequent request.
+func DialStorage(addr string, timeout time.Duration) (*Storage, error) {
+	c, err := dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{c}, nil
+}
+
+// DialStorageContext connects to the storage server at addr. The deadline
+// of ctx bounds the dial and every subsequent request, and the connection
+// is closed as soon as ctx is done.
+func DialStorageContext(ctx context.Context, addr string) (*Storage, error) {
+	c, err := dialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{c}, nil
+}
+
+// Close closes the connection to the storage server.
+func (s *Storage) Close() error {
+	return s.conn.Close()
+}
+
+// ActiveTest checks that the storage server answers requests.
+func (s *Storage) ActiveTest() error {
+	_, err := s.conn.call(FdfsProtoCmdActiveTest)
+	return err
+}
+
+// Upload stores data in the store path with the given index and returns
+// the group and the remote file name the server assigned. The extension
+// is cut to FileExtNameMaxLen bytes.
+func (s *Storage) Upload(storePathIndex byte, ext string, data []byte) (group, name string, err error) {
+	if len(ext) > FileExtNameMaxLen {
+		ext = ext[:FileExtNameMaxLen]
+	}
+	head := make([]byte, 1+8)
+	head[0] = storePathIndex
+	binary.BigEndian.PutUint64(head[1:], uint64(len(data)))
+	body, err := s.conn.call(StorageProtoCmdUploadFile, head, fixedString(ext, FileExtNameMaxLen), data)
+	if err != nil {
+		return "", "", err
+	}
+	if len(body) <= GroupNameMaxLen {
+		return "", "", fmt.Errorf("fdfs: upload response is %d bytes, want more than %d", len(body), GroupNameMaxLen)
+	}
+	return cString(body[:GroupNameMaxLen]), string(body[GroupNameMaxLen:]), nil
+}
+
+// Download returns the content of the named file of group.
+func (s *Storage) Download(group, name string) ([]byte, error) {
+	// A zero offset and length ask for the whole file.
+	var offsets [16]byte
+	return s.conn.call(StorageProtoCmdDownloadFile, offsets[:], fixedString(group, GroupNameMaxLen), []byte(name))
+}
+
+// Delete removes the named file of group.
+func (s *Storage) Delete(group, name string) error {
+	_, err := s.conn.call(StorageProtoCmdDeleteFile, fixedString(group, GroupNameMaxLen), []byte(name))
+	return err
+}
+
+// FileInfo is the answer to QUERY_FILE_INFO. CreateTimestamp is in Unix
+// seconds.
+type FileInfo struct {
+	Size            int64
+	CreateTimestamp int64
+	CRC32           uint32
+	SourceIPAddr    string
+}
+
+// fileInfoSize is the wire size of a FileInfo.
+const fileInfoSize = 3*8 + IPAddressSize
+
+// QueryFileInfo returns the size, creation time, checksum and source of the
+// named file of group. A server that does not have the file, for example
+// because it has not been replicated there yet, answers with ENOENT.
+func (s *Storage) QueryFileInfo(group, name string) (FileInfo, error) {
+	var info FileInfo
+	body, err := s.conn.call(StorageProtoCmdQueryFileInfo, fixedString(group, GroupNameMaxLen), []byte(name))
+	if err != nil {
+		return info, err
+	}
+	if len(body) != fileInfoSize {
+		return info, fmt.Errorf("fdfs: file info is %d bytes, want %d", len(body), fileInfoSize)
+	}
+	info.Size = int64(binary.BigEndian.Uint64(body[0:8]))
+	info.CreateTimestamp = int64(binary.BigEndian.Uint64(body[8:16]))
+	info.CRC32 = uint32(binary.BigEndian.Uint64(body[16:24]))
+	info.SourceIPAddr = cString(body[24:])
+	return info, nil
+}
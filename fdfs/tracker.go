@@ -0,0 +1,148 @@
+package fdfs
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Tracker is a connection to a FastDFS tracker server. It is not safe for
+// concurrent use.
+type Tracker struct {
+	conn *conn
+}
+
+// DialTracker connects to the tracker at addr. The timeout bounds the dial
+// and every subsequent request.
+func DialTracker(addr string, timeout time.Duration) (*Tracker, error) {
+	c, err := dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracker{c}, nil
+}
+
+// DialTrackerContext connects to the tracker at addr. The deadline of ctx
+// bounds the dial and every subsequent request, and the connection is
+// closed as soon as ctx is done.
+func DialTrackerContext(ctx context.Context, addr string) (*Tracker, error) {
+	c, err := dialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracker{c}, nil
+}
+
+// Close closes the connection to the tracker.
+func (t *Tracker) Close() error {
+	return t.conn.Close()
+}
+
+// Status returns whether the tracker is the leader and how long it has been
+// running.
+func (t *Tracker) Status() (TrackerStatus, error) {
+	var s TrackerStatus
+	body, err := t.conn.call(TrackerProtoCmdTrackerGetStatus, nil)
+	if err != nil {
+		return s, err
+	}
+	err = s.UnmarshalBinary(body)
+	return s, err
+}
+
+// ListGroups returns the stats of every group known to the tracker.
+func (t *Tracker) ListGroups() ([]GroupStat, error) {
+	body, err := t.conn.call(TrackerProtoCmdServerListAllGroups, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(body)%GroupStatSize != 0 {
+		return nil, fmt.Errorf("fdfs: group list of %d bytes is not a multiple of %d", len(body), GroupStatSize)
+	}
+	groups := make([]GroupStat, len(body)/GroupStatSize)
+	for i := range groups {
+		if err := groups[i].UnmarshalBinary(body[i*GroupStatSize : (i+1)*GroupStatSize]); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// ListOneGroup returns the stats of the named group.
+func (t *Tracker) ListOneGroup(group string) (GroupStat, error) {
+	var g GroupStat
+	body, err := t.conn.call(TrackerProtoCmdServerListOneGroup, fixedString(group, GroupNameMaxLen))
+	if err != nil {
+		return g, err
+	}
+	err = g.UnmarshalBinary(body)
+	return g, err
+}
+
+// ListStorage returns the stats of the storage servers in group. When
+// storageID is not empty only that server is returned.
+func (t *Tracker) ListStorage(group, storageID string) ([]StorageStat, error) {
+	req := fixedString(group, GroupNameMaxLen)
+	if storageID != "" {
+		req = append(req, storageID...)
+	}
+	body, err := t.conn.call(TrackerProtoCmdServerListStorage, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(body)%StorageStatSize != 0 {
+		return nil, fmt.Errorf("fdfs: storage list of %d bytes is not a multiple of %d", len(body), StorageStatSize)
+	}
+	storages := make([]StorageStat, len(body)/StorageStatSize)
+	for i := range storages {
+		if err := storages[i].UnmarshalBinary(body[i*StorageStatSize : (i+1)*StorageStatSize]); err != nil {
+			return nil, err
+		}
+	}
+	return storages, nil
+}
+
+// StoreServer is the storage server a tracker picks for an upload.
+type StoreServer struct {
+	Group          string
+	IPAddr         string
+	Port           int64
+	StorePathIndex byte
+}
+
+// Addr returns the host:port of the storage server.
+func (s StoreServer) Addr() string {
+	return net.JoinHostPort(s.IPAddr, strconv.FormatInt(s.Port, 10))
+}
+
+// storeServerSize is the wire size of a StoreServer.
+const storeServerSize = GroupNameMaxLen + IPAddressSize - 1 + 8 + 1
+
+// QueryStore asks the tracker which storage server of group to upload to.
+// An empty group lets the tracker pick the group as well.
+func (t *Tracker) QueryStore(group string) (StoreServer, error) {
+	var s StoreServer
+	var body []byte
+	var err error
+	if group == "" {
+		body, err = t.conn.call(TrackerProtoCmdServiceQueryStoreWithoutGroupOne)
+	} else {
+		body, err = t.conn.call(TrackerProtoCmdServiceQueryStoreWithGroupOne, fixedString(group, GroupNameMaxLen))
+	}
+	if err != nil {
+		return s, err
+	}
+	if len(body) < storeServerSize {
+		return s, fmt.Errorf("fdfs: store server is %d bytes, want %d", len(body), storeServerSize)
+	}
+	s.Group = cString(body[:GroupNameMaxLen])
+	body = body[GroupNameMaxLen:]
+	s.IPAddr = cString(body[:IPAddressSize-1])
+	body = body[IPAddressSize-1:]
+	s.Port = int64(binary.BigEndian.Uint64(body[:8]))
+	s.StorePathIndex = body[8]
+	return s, nil
+}
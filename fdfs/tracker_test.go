@@ -0,0 +1,219 @@
+package fdfs_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+// The sizes of the records as the FastDFS 5 and 6 trackers send them.
+func TestWireSizes(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		got, want int
+	}{
+		{"TrackerStatus", fdfs.TrackerStatusSize, 17},
+		{"GroupStat", fdfs.GroupStatSize, 105},
+		{"StorageStat", fdfs.StorageStatSize, 612},
+		{"StoreServer", fdfs.StoreServerSize, 40},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s is %d bytes on the wire, want %d", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestTrackerRoundTrip(t *testing.T) {
+	status := fdfs.TrackerStatus{IfLeader: true, RunningTime: 3600, RestartInterval: 60}
+	group1 := fdfs.GroupStat{Name: "group1", TotalMB: 1000, FreeMB: 400, StorageCount: 2, ActiveCount: 1, StoragePort: 23000}
+	group2 := fdfs.GroupStat{Name: "group2", TotalMB: 2000, FreeMB: 1500, StorageCount: 1, ActiveCount: 1}
+	s1 := fdfs.StorageStat{
+		Status:      fdfs.StorageStatusOffline,
+		ID:          "s1",
+		IPAddr:      "10.0.0.1",
+		Version:     "6.07",
+		StoragePort: 23000,
+		StorageCounters: fdfs.StorageCounters{
+			TotalUploadCount:  10,
+			LastHeartBeatTime: 1500000000,
+		},
+	}
+	s2 := fdfs.StorageStat{Status: fdfs.StorageStatusActive, ID: "s2", IPAddr: "10.0.0.2", StoragePort: 23000, IfTrunkServer: true}
+	s3 := fdfs.StorageStat{Status: fdfs.StorageStatusActive, ID: "s3", IPAddr: "10.0.0.3", StoragePort: 23001}
+
+	fake, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	fake.SetStatus(status)
+	fake.AddGroup(group1, s1, s2)
+	fake.AddGroup(group2, s3)
+
+	tracker, err := fdfs.DialTracker(fake.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+
+	gotStatus, err := tracker.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStatus != status {
+		t.Errorf("Status() = %+v, want %+v", gotStatus, status)
+	}
+
+	groups, err := tracker.ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []fdfs.GroupStat{group1, group2}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("ListGroups() = %+v, want %+v", groups, want)
+	}
+
+	group, err := tracker.ListOneGroup("group2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != group2 {
+		t.Errorf("ListOneGroup(group2) = %+v, want %+v", group, group2)
+	}
+
+	storages, err := tracker.ListStorage("group1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []fdfs.StorageStat{s1, s2}; !reflect.DeepEqual(storages, want) {
+		t.Errorf("ListStorage(group1) = %+v, want %+v", storages, want)
+	}
+
+	storages, err = tracker.ListStorage("group1", "s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []fdfs.StorageStat{s2}; !reflect.DeepEqual(storages, want) {
+		t.Errorf("ListStorage(group1, s2) = %+v, want %+v", storages, want)
+	}
+
+	store, err := tracker.QueryStore("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (fdfs.StoreServer{Group: "group1", IPAddr: "10.0.0.2", Port: 23000}); store != want {
+		t.Errorf("QueryStore(group1) = %+v, want %+v", store, want)
+	}
+	if got, want := store.Addr(), "10.0.0.2:23000"; got != want {
+		t.Errorf("Addr() = %q, want %q", got, want)
+	}
+}
+
+func TestTrackerStatusError(t *testing.T) {
+	fake, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	tracker, err := fdfs.DialTracker(fake.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+
+	_, err = tracker.ListOneGroup("missing")
+	serr, ok := err.(*fdfs.StatusError)
+	if !ok {
+		t.Fatalf("ListOneGroup(missing) returned %v, want a *StatusError", err)
+	}
+	if serr.Cmd != fdfs.TrackerProtoCmdServerListOneGroup || serr.Status != 2 {
+		t.Errorf("got %+v, want command %d with status 2", serr, fdfs.TrackerProtoCmdServerListOneGroup)
+	}
+
+	// The connection stays usable after an error status.
+	if _, err := tracker.ListGroups(); err != nil {
+		t.Errorf("ListGroups() after an error status: %v", err)
+	}
+}
+
+// answerWith starts a server answering every request with body. Callers
+// should Close the listener when done.
+func answerWith(t *testing.T, body []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					h, err := fdfs.ReadHeader(conn)
+					if err != nil {
+						return
+					}
+					if _, err := io.CopyN(ioutil.Discard, conn, h.Length); err != nil {
+						return
+					}
+					resp := fdfs.Header{Length: int64(len(body)), Cmd: fdfs.TrackerProtoCmdResp}
+					if fdfs.WriteHeader(conn, resp) != nil {
+						return
+					}
+					if _, err := conn.Write(body); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTrackerShortBody(t *testing.T) {
+	l := answerWith(t, make([]byte, 10))
+	defer l.Close()
+	tracker, err := fdfs.DialTracker(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+
+	if _, err := tracker.Status(); err == nil {
+		t.Error("Status() accepted a 10 byte answer")
+	}
+	if _, err := tracker.ListGroups(); err == nil {
+		t.Error("ListGroups() accepted a 10 byte answer")
+	}
+	if _, err := tracker.ListOneGroup("group1"); err == nil {
+		t.Error("ListOneGroup() accepted a 10 byte answer")
+	}
+	if _, err := tracker.ListStorage("group1", ""); err == nil {
+		t.Error("ListStorage() accepted a 10 byte answer")
+	}
+	if _, err := tracker.QueryStore("group1"); err == nil {
+		t.Error("QueryStore() accepted a 10 byte answer")
+	}
+}
+
+func TestTrackerListsPartialRecords(t *testing.T) {
+	l := answerWith(t, make([]byte, fdfs.GroupStatSize+1))
+	defer l.Close()
+	tracker, err := fdfs.DialTracker(l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+
+	if _, err := tracker.ListGroups(); err == nil {
+		t.Error("ListGroups() accepted a trailing partial record")
+	}
+}
@@ -0,0 +1,61 @@
+// group.go
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupMetric maps one field of the fdfs_monitor group section to a gauge.
+type groupMetric struct {
+	desc  *prometheus.Desc
+	key   string
+	bytes bool
+}
+
+var (
+	groupLabels  = []string{"group"}
+	groupMetrics = []groupMetric{
+		{newGroupDesc("disk_total_bytes", "Total disk space of the group in bytes."), "disk total space", true},
+		{newGroupDesc("disk_free_bytes", "Free disk space of the group in bytes."), "disk free space", true},
+		{newGroupDesc("trunk_free_bytes", "Free trunk space of the group in bytes."), "trunk free space", true},
+		{newGroupDesc("storage_server_count", "Number of storage servers in the group."), "storage server count", false},
+		{newGroupDesc("active_server_count", "Number of active storage servers in the group."), "active server count", false},
+		{newGroupDesc("store_path_count", "Number of store paths on each storage server of the group."), "store path count", false},
+		{newGroupDesc("subdir_count_per_path", "Number of subdirectories per store path."), "subdir count per path", false},
+	}
+)
+
+func newGroupDesc(name, help string) *prometheus.Desc {
+	return newDesc(
+		prometheus.BuildFQName(namespace, "group", name),
+		help, groupLabels, nil,
+	)
+}
+
+func describeGroupMetrics(ch chan<- *prometheus.Desc) {
+	for _, m := range groupMetrics {
+		ch <- m.desc
+	}
+}
+
+func collectGroupMetrics(ch chan<- prometheus.Metric, cluster *Cluster) {
+	for _, g := range cluster.Groups {
+		for _, m := range groupMetrics {
+			var (
+				value int64
+				ok    bool
+			)
+			if m.bytes {
+				value, ok = g.Fields.Bytes(m.key)
+			} else {
+				value, ok = g.Fields.Int(m.key)
+			}
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				m.desc, prometheus.GaugeValue, float64(value), g.Name,
+			)
+		}
+	}
+}
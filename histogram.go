@@ -0,0 +1,36 @@
+// histogram.go
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// histogram accumulates observations for a const histogram, so histograms
+// can use descs from newDesc like every other metric of the exporter.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metric returns the observations so far as a histogram of desc.
+func (h *histogram) metric(desc *prometheus.Desc, labelValues ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.buckets))
+	for i, upper := range h.buckets {
+		buckets[upper] = h.counts[i]
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, labelValues...)
+}
@@ -0,0 +1,244 @@
+// httpprobe.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+var (
+	httpProbeLabels = []string{"group", "via", "endpoint"}
+	httpProbeStatus = newDesc(
+		prometheus.BuildFQName(namespace, "http_probe", "status_code"),
+		"HTTP status code of the last download of the probe file from the endpoint, 0 when no response arrived or there was no file to download.",
+		httpProbeLabels, nil,
+	)
+	httpProbeDuration = newDesc(
+		prometheus.BuildFQName(namespace, "http_probe", "duration_seconds"),
+		"How long downloading the probe file from the endpoint took.",
+		httpProbeLabels, nil,
+	)
+	httpProbeContentMatch = newDesc(
+		prometheus.BuildFQName(namespace, "http_probe", "content_match"),
+		"Whether the probe file last downloaded from the endpoint had the expected content.",
+		httpProbeLabels, nil,
+	)
+)
+
+// maxHTTPProbeSize caps how much of a probe file is read.
+const maxHTTPProbeSize = 64 << 20
+
+// httpEndpoint is an HTTP server a file of group is downloaded from: the
+// storage_http_port of a storage server, or the nginx module at Nginx_IP.
+type httpEndpoint struct {
+	group, via, addr string
+}
+
+// httpResult is the outcome of downloading the probe file from an endpoint.
+type httpResult struct {
+	endpoint httpEndpoint
+	status   int
+	duration time.Duration
+	match    bool
+}
+
+// httpEndpoints returns the endpoints serving the files of group: every
+// ACTIVE member with an HTTP port and, when FastDFS.json was read, the
+// nginx module at its Nginx_IP.
+func httpEndpoints(state clusterState, group string) []httpEndpoint {
+	var endpoints []httpEndpoint
+	if state.cluster != nil {
+		for _, g := range state.cluster.Groups {
+			if g.Name != group {
+				continue
+			}
+			for _, s := range g.Storages {
+				if addr, ok := g.StorageHTTPAddr(s); ok && s.Status == "ACTIVE" {
+					endpoints = append(endpoints, httpEndpoint{group, "storage", addr})
+				}
+			}
+		}
+	}
+	if state.config != nil && state.config.Nginx_IP != "" {
+		addr := strings.TrimPrefix(strings.TrimPrefix(state.config.Nginx_IP, "http://"), "https://")
+		endpoints = append(endpoints, httpEndpoint{group, "nginx", strings.TrimSuffix(addr, "/")})
+	}
+	return endpoints
+}
+
+// probeHTTP downloads the file of group from every endpoint in parallel
+// and checks its content with verify, all within the canary timeout.
+func (c *canary) probeHTTP(ctx context.Context, state clusterState, group, name string, verify func([]byte) bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	endpoints := httpEndpoints(state, group)
+	results := make([]httpResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(r *httpResult, e httpEndpoint) {
+			defer wg.Done()
+			r.endpoint = e
+			url := fmt.Sprintf("http://%s/%s/%s", e.addr, group, name)
+			start := time.Now()
+			status, body, err := httpGet(ctx, url)
+			r.status, r.duration = status, time.Since(start)
+			if err != nil {
+				log.Errorf("HTTP probe of %s failed: %v", url, err)
+				return
+			}
+			if status != http.StatusOK {
+				log.Errorf("HTTP probe of %s returned status %d", url, status)
+				return
+			}
+			if r.match = verify(body); !r.match {
+				log.Errorf("HTTP probe of %s returned %d bytes that differ from the file", url, len(body))
+			}
+		}(&results[i], e)
+	}
+	wg.Wait()
+	c.recordHTTP(group, results)
+}
+
+// probeKnownFile runs the HTTP probe against the file configured with
+// http_probe_file, checking downloads against the size and CRC32 a member
+// of its group reports for it.
+func (c *canary) probeKnownFile(ctx context.Context, state clusterState) {
+	group, name := c.httpFileGroup, c.httpFileName
+	qctx, cancel := context.WithTimeout(ctx, c.timeout)
+	info, err := fileInfo(qctx, state, group, name)
+	cancel()
+	if err != nil {
+		log.Errorf("HTTP probe could not look up %s/%s: %v", group, name, err)
+		c.failHTTP(state, group)
+		return
+	}
+	c.probeHTTP(ctx, state, group, name, func(body []byte) bool {
+		return int64(len(body)) == info.Size && crc32.ChecksumIEEE(body) == info.CRC32
+	})
+}
+
+// fileInfo asks the ACTIVE members of group for the file until one
+// answers.
+func fileInfo(ctx context.Context, state clusterState, group, name string) (fdfs.FileInfo, error) {
+	var info fdfs.FileInfo
+	err := fmt.Errorf("no ACTIVE storage server of group %s known", group)
+	if state.cluster == nil {
+		return info, err
+	}
+	for _, g := range state.cluster.Groups {
+		if g.Name != group {
+			continue
+		}
+		for _, s := range g.Storages {
+			addr, ok := g.StorageAddr(s)
+			if !ok || s.Status != "ACTIVE" {
+				continue
+			}
+			if err = withStorage(ctx, addr, func(storage *fdfs.Storage) (err error) {
+				info, err = storage.QueryFileInfo(group, name)
+				return err
+			}); err == nil {
+				return info, nil
+			}
+		}
+	}
+	return info, err
+}
+
+// httpGet downloads url, returning the status code and body of the
+// response.
+func httpGet(ctx context.Context, url string) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPProbeSize))
+	return resp.StatusCode, body, err
+}
+
+// splitFileID splits "group1/M00/00/00/name.ext" into the group and the
+// remote file name.
+func splitFileID(id string) (group, name string, err error) {
+	i := strings.IndexByte(id, '/')
+	if i <= 0 || i == len(id)-1 {
+		return "", "", fmt.Errorf("file ID %q is not of the form group/remote_filename", id)
+	}
+	return id[:i], id[i+1:], nil
+}
+
+// failHTTP marks every endpoint of group as failed, for runs that had no
+// file to download.
+func (c *canary) failHTTP(state clusterState, group string) {
+	endpoints := httpEndpoints(state, group)
+	results := make([]httpResult, len(endpoints))
+	for i, e := range endpoints {
+		results[i].endpoint = e
+	}
+	c.recordHTTP(group, results)
+}
+
+// recordHTTP replaces the HTTP probe results of group.
+func (c *canary) recordHTTP(group string, results []httpResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current := map[httpEndpoint]bool{}
+	for _, r := range results {
+		current[r.endpoint] = true
+		c.httpStatus[r.endpoint] = r.status
+		c.httpMatch[r.endpoint] = r.match
+		if r.status == 0 {
+			continue
+		}
+		h := c.httpDurations[r.endpoint]
+		if h == nil {
+			h = newHistogram(prometheus.DefBuckets)
+			c.httpDurations[r.endpoint] = h
+		}
+		h.observe(r.duration.Seconds())
+	}
+	for e := range c.httpStatus {
+		if e.group == group && !current[e] {
+			delete(c.httpStatus, e)
+			delete(c.httpMatch, e)
+			delete(c.httpDurations, e)
+		}
+	}
+}
+
+func describeHTTPProbeMetrics(ch chan<- *prometheus.Desc) {
+	ch <- httpProbeStatus
+	ch <- httpProbeDuration
+	ch <- httpProbeContentMatch
+}
+
+// collectHTTP sends the HTTP probe results. The caller holds c.mu.
+func (c *canary) collectHTTP(ch chan<- prometheus.Metric) {
+	for e, status := range c.httpStatus {
+		var match float64
+		if c.httpMatch[e] {
+			match = 1
+		}
+		ch <- prometheus.MustNewConstMetric(httpProbeStatus, prometheus.GaugeValue, float64(status), e.group, e.via, e.addr)
+		ch <- prometheus.MustNewConstMetric(httpProbeContentMatch, prometheus.GaugeValue, match, e.group, e.via, e.addr)
+	}
+	for e, h := range c.httpDurations {
+		ch <- h.metric(httpProbeDuration, e.group, e.via, e.addr)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+// member is a fake storage server serving its files over the storage
+// protocol and HTTP.
+type member struct {
+	*fdfstest.Storage
+	http *httptest.Server
+}
+
+func newMember(t *testing.T, group string) *member {
+	s, err := fdfstest.NewStorage(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &member{s, httptest.NewServer(s)}
+}
+
+func (m *member) Close() {
+	m.http.Close()
+	m.Storage.Close()
+}
+
+// storage returns m as an ACTIVE storage server of the topology.
+func (m *member) storage(group string) *Storage {
+	_, port, _ := net.SplitHostPort(m.Addr)
+	_, httpPort, _ := net.SplitHostPort(m.http.Listener.Addr().String())
+	return &Storage{
+		Group:  group,
+		ID:     m.Addr,
+		IP:     "127.0.0.1",
+		Status: "ACTIVE",
+		Fields: Fields{"storage_port": port, "storage_http_port": httpPort},
+	}
+}
+
+// endpoint returns the label set of the HTTP probe results of m.
+func (m *member) endpoint(group string) string {
+	return "endpoint=" + m.http.Listener.Addr().String() + ",group=" + group + ",via=storage"
+}
+
+func stateOf(group string, members ...*member) clusterState {
+	g := &Group{Name: group, Fields: Fields{}}
+	for _, m := range members {
+		g.Storages = append(g.Storages, m.storage(group))
+	}
+	return clusterState{cluster: &Cluster{Groups: []*Group{g}}}
+}
+
+func upload(t *testing.T, m *member, data []byte) (group, name string) {
+	s, err := fdfs.DialStorage(m.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	group, name, err = s.Upload(0, "txt", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return group, name
+}
+
+func remove(t *testing.T, m *member, group, name string) {
+	s, err := fdfs.DialStorage(m.Addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Delete(group, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPProbeKnownFile(t *testing.T) {
+	m := newMember(t, "group1")
+	defer m.Close()
+	state := stateOf("group1", m)
+	group, name := upload(t, m, []byte("hello"))
+
+	c := newCanary(FastDFSConfig{
+		CanaryInterval: time.Minute,
+		CanaryTimeout:  time.Second,
+		CanarySize:     16,
+		HTTPProbeFile:  group + "/" + name,
+	})
+	c.probeKnownFile(context.Background(), state)
+	metrics := gatherFunc(c.collect)
+	want := map[string]float64{m.endpoint("group1"): 200}
+	if got := values(t, metrics, httpProbeStatus); !reflect.DeepEqual(got, want) {
+		t.Errorf("status codes = %v, want %v", got, want)
+	}
+	want = map[string]float64{m.endpoint("group1"): 1}
+	if got := values(t, metrics, httpProbeContentMatch); !reflect.DeepEqual(got, want) {
+		t.Errorf("content matches = %v, want %v", got, want)
+	}
+
+	// Without the file nothing can be downloaded, which must show as a
+	// failure rather than as missing series.
+	remove(t, m, group, name)
+	c.probeKnownFile(context.Background(), state)
+	metrics = gatherFunc(c.collect)
+	want = map[string]float64{m.endpoint("group1"): 0}
+	if got := values(t, metrics, httpProbeStatus); !reflect.DeepEqual(got, want) {
+		t.Errorf("status codes after the lookup failed = %v, want %v", got, want)
+	}
+	if got := values(t, metrics, httpProbeContentMatch); !reflect.DeepEqual(got, want) {
+		t.Errorf("content matches after the lookup failed = %v, want %v", got, want)
+	}
+	want = map[string]float64{m.endpoint("group1"): 1}
+	if got := values(t, metrics, httpProbeDuration); !reflect.DeepEqual(got, want) {
+		t.Errorf("durations after the lookup failed = %v, want only the download", got)
+	}
+}
@@ -0,0 +1,249 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to one Kubernetes API server.
+type Client struct {
+	host   *url.URL
+	config *Config
+	http   *http.Client
+}
+
+// NewClient returns a client for the API server described by c.
+func NewClient(c *Config) (*Client, error) {
+	host, err := url.Parse(c.Host)
+	if err != nil {
+		return nil, err
+	}
+	if host.Scheme != "http" && host.Scheme != "https" {
+		return nil, fmt.Errorf("kube: API server %q must be an http or https URL", c.Host)
+	}
+	tc, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	// A transport with a custom TLS config only speaks HTTP/1.1, which the
+	// WebSocket upgrade used by exec requires.
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tc,
+	}
+	return &Client{
+		host:   host,
+		config: c,
+		http:   &http.Client{Transport: transport},
+	}, nil
+}
+
+// Pod is the part of a pod the exporter cares about.
+type Pod struct {
+	Name  string
+	IP    string
+	Phase string
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Phase string `json:"phase"`
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// Status is the error object returned by the API server.
+type Status struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
+
+// StatusError is returned when the API server rejects a request.
+type StatusError struct {
+	Status
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "kube: " + e.Message
+	}
+	return fmt.Sprintf("kube: request failed with %d %s", e.Code, e.Reason)
+}
+
+// ListPods returns the pods in namespace matching the label selector.
+func (c *Client) ListPods(ctx context.Context, namespace, selector string) ([]Pod, error) {
+	query := url.Values{}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	p, err := apiPath("api", "v1", "namespaces", namespace, "pods")
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.request(ctx, "GET", p, query)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var list podList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	pods := make([]Pod, 0, len(list.Items))
+	for _, item := range list.Items {
+		pods = append(pods, Pod{
+			Name:  item.Metadata.Name,
+			IP:    item.Status.PodIP,
+			Phase: item.Status.Phase,
+		})
+	}
+	return pods, nil
+}
+
+// Exec runs command in a container of the pod through the API server's
+// streaming exec endpoint and returns what it wrote to stdout. The container
+// may be empty for single container pods. A non-zero exit status is returned
+// as an error carrying the command's stderr. When ctx is done the session is
+// closed and ctx.Err() returned.
+func (c *Client) Exec(ctx context.Context, namespace, pod, container string, command []string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("stdout", "true")
+	query.Set("stderr", "true")
+	if container != "" {
+		query.Set("container", container)
+	}
+	for _, arg := range command {
+		query.Add("command", arg)
+	}
+	p, err := apiPath("api", "v1", "namespaces", namespace, "pods", pod, "exec")
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.request(ctx, "GET", p, query)
+	if err != nil {
+		return nil, err
+	}
+	key, err := websocketKey()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Protocol", execProtocol)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kube: exec connection was not upgraded")
+	}
+	defer conn.Close()
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return nil, fmt.Errorf("kube: invalid WebSocket handshake")
+	}
+	// The request context no longer applies once the connection has been
+	// upgraded; closing it is what stops a hung session.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	status, err := readExecStreams(conn, &stdout, &stderr)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != nil && status.Status != "Success" {
+		msg := status.Message
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			msg += ": " + s
+		}
+		return stdout.Bytes(), &StatusError{Status{Status: status.Status, Message: msg, Reason: status.Reason, Code: status.Code}}
+	}
+	return stdout.Bytes(), nil
+}
+
+// apiPath joins the segments of an API path, escaping each one so that a
+// name cannot reach into another segment or resource.
+func apiPath(segments ...string) (string, error) {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			return "", fmt.Errorf("kube: invalid name %q in API path", s)
+		}
+		escaped[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(escaped, "/"), nil
+}
+
+// request returns a request for the escaped API path p.
+func (c *Client) request(ctx context.Context, method, p string, query url.Values) (*http.Request, error) {
+	u := *c.host
+	u.RawPath = strings.TrimSuffix(c.host.EscapedPath(), "/") + p
+	var err error
+	if u.Path, err = url.PathUnescape(u.RawPath); err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.config.authorize(req); err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+// responseError turns a failed response into a StatusError, using the
+// Status object in the body when the API server sent one.
+func responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	e := &StatusError{}
+	if err := json.Unmarshal(b, &e.Status); err != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(b))
+		if e.Message == "" {
+			e.Message = resp.Status
+		}
+	}
+	e.Code = resp.StatusCode
+	return e
+}
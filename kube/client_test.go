@@ -0,0 +1,175 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIPathEscapesNames(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+		w.Write([]byte(`{"items": []}`))
+	}))
+	defer server.Close()
+	c, err := NewClient(&Config{Host: server.URL + "/prefix/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ListPods(context.Background(), "kube-system/pods/etcd-0", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/prefix/api/v1/namespaces/kube-system%2Fpods%2Fetcd-0/pods"; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+
+	got = ""
+	for _, pod := range []string{"..", ".", ""} {
+		if _, err := c.Exec(context.Background(), "default", pod, "", []string{"true"}); err == nil {
+			t.Errorf("Exec in pod %q succeeded", pod)
+		}
+	}
+	if got != "" {
+		t.Errorf("Exec requested %s", got)
+	}
+}
+
+// execServer is an API server whose exec endpoint upgrades to the
+// v4.channel.k8s.io protocol and sends frames, then waits for the client to
+// close the session. The request it got is kept in req.
+type execServer struct {
+	*httptest.Server
+	frames [][]byte
+	req    *http.Request
+	// closed receives what the client sent after the last frame.
+	closed chan []byte
+}
+
+func newExecServer(t *testing.T, frames ...[]byte) *execServer {
+	s := &execServer{frames: frames, closed: make(chan []byte, 1)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.req = r
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Protocol") != execProtocol {
+			http.Error(w, "not an exec upgrade", http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: %s\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")), execProtocol)
+		for _, f := range s.frames {
+			rw.Write(f)
+		}
+		rw.Flush()
+		b, _ := ioutil.ReadAll(rw)
+		s.closed <- b
+	}))
+	return s
+}
+
+func newTestClient(t *testing.T, url string) *Client {
+	c, err := NewClient(&Config{Host: url, BearerToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestExec(t *testing.T) {
+	s := newExecServer(t,
+		serverFrame(true, opBinary, channel(channelStdout, "group count: 1\n")),
+		serverFrame(true, opBinary, channel(channelStderr, "ignored on success")),
+		serverFrame(true, opBinary, channel(channelError, `{"status": "Success"}`)),
+		serverFrame(true, opClose, nil),
+	)
+	defer s.Close()
+
+	out, err := newTestClient(t, s.URL).Exec(context.Background(), "fastdfs", "storage-0", "storage", []string{"cat", "/etc/fdfs/storage.conf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "group count: 1\n" {
+		t.Errorf("got stdout %q", out)
+	}
+	b := <-s.closed
+	if got, want := s.req.URL.Path, "/api/v1/namespaces/fastdfs/pods/storage-0/exec"; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+	q := s.req.URL.Query()
+	if !reflect.DeepEqual(q["command"], []string{"cat", "/etc/fdfs/storage.conf"}) || q.Get("container") != "storage" || q.Get("stdout") != "true" {
+		t.Errorf("got query %v", q)
+	}
+	if got := s.req.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got Authorization %q", got)
+	}
+
+	// The close frame is echoed masked.
+	if len(b) < 2 || b[1]&0x80 == 0 {
+		t.Fatalf("client closed with %q, want a masked frame", b)
+	}
+	if _, op, _, err := readFrame(bytes.NewReader(b)); err != nil || op != opClose {
+		t.Errorf("client answered the close with op %d: %v", op, err)
+	}
+}
+
+func TestExecFailure(t *testing.T) {
+	s := newExecServer(t,
+		serverFrame(true, opBinary, channel(channelStdout, "partial")),
+		serverFrame(true, opBinary, channel(channelStderr, "fdfs_monitor: connect to tracker failed\n")),
+		serverFrame(true, opBinary, channel(channelError, `{"status": "Failure", "message": "command terminated with non-zero exit code", "reason": "NonZeroExitCode", "code": 500}`)),
+		serverFrame(true, opClose, nil),
+	)
+	defer s.Close()
+
+	out, err := newTestClient(t, s.URL).Exec(context.Background(), "fastdfs", "storage-0", "", []string{"fdfs_monitor"})
+	serr, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("got error %v, want a StatusError", err)
+	}
+	if serr.Reason != "NonZeroExitCode" || !strings.HasSuffix(serr.Message, ": fdfs_monitor: connect to tracker failed") {
+		t.Errorf("got status %+v, want the failure with stderr", serr.Status)
+	}
+	if string(out) != "partial" {
+		t.Errorf("got stdout %q", out)
+	}
+}
+
+func TestExecRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"status": "Failure", "message": "pods \"storage-0\" is forbidden", "reason": "Forbidden", "code": 403}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestClient(t, server.URL).Exec(context.Background(), "fastdfs", "storage-0", "", []string{"true"})
+	serr, ok := err.(*StatusError)
+	if !ok || serr.Code != 403 || serr.Reason != "Forbidden" {
+		t.Errorf("got error %#v, want the 403 Status", err)
+	}
+}
+
+func TestExecContextDone(t *testing.T) {
+	// The session never ends on its own.
+	s := newExecServer(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := newTestClient(t, s.URL).Exec(ctx, "fastdfs", "storage-0", "", []string{"sleep", "3600"}); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
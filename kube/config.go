@@ -0,0 +1,245 @@
+// Package kube is a minimal Kubernetes API client covering what the exporter
+// needs: listing pods and running commands in them.
+package kube
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Config holds the address of an API server and the credentials used to
+// talk to it. File fields are read when the client is built, except
+// BearerTokenFile which is re-read on every request so rotated tokens are
+// picked up.
+type Config struct {
+	Host string
+
+	BearerToken     string
+	BearerTokenFile string
+	Username        string
+	Password        string
+
+	CAFile   string
+	CAData   []byte
+	CertFile string
+	CertData []byte
+	KeyFile  string
+	KeyData  []byte
+	Insecure bool
+
+	// Namespace is the default namespace of the kubeconfig context, or of
+	// the service account when running in a cluster.
+	Namespace string
+}
+
+// InClusterConfig returns the configuration of the service account the
+// process runs as inside a pod.
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kube: not running in a cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are unset")
+	}
+	c := &Config{
+		Host:            "https://" + net.JoinHostPort(host, port),
+		BearerTokenFile: filepath.Join(serviceAccountDir, "token"),
+		CAFile:          filepath.Join(serviceAccountDir, "ca.crt"),
+	}
+	if ns, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil {
+		c.Namespace = strings.TrimSpace(string(ns))
+	}
+	return c, nil
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// LoadKubeconfig reads the named context of a kubeconfig file, or its
+// current context when context is empty. Relative file references are
+// resolved against the directory of the kubeconfig.
+func LoadKubeconfig(path, context string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("kube: parsing %s: %v", path, err)
+	}
+	if context == "" {
+		context = kc.CurrentContext
+	}
+
+	c := &Config{}
+	found := false
+	var clusterName, userName string
+	for _, ctx := range kc.Contexts {
+		if ctx.Name == context {
+			clusterName, userName = ctx.Context.Cluster, ctx.Context.User
+			c.Namespace = ctx.Context.Namespace
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kube: context %q not found in %s", context, path)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	found = false
+	for _, cl := range kc.Clusters {
+		if cl.Name != clusterName {
+			continue
+		}
+		c.Host = cl.Cluster.Server
+		c.CAFile = resolve(cl.Cluster.CertificateAuthority)
+		c.Insecure = cl.Cluster.InsecureSkipTLSVerify
+		if c.CAData, err = decodeData(cl.Cluster.CertificateAuthorityData); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("kube: cluster %q not found in %s", clusterName, path)
+	}
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		c.BearerToken = u.User.Token
+		c.BearerTokenFile = resolve(u.User.TokenFile)
+		c.Username = u.User.Username
+		c.Password = u.User.Password
+		c.CertFile = resolve(u.User.ClientCertificate)
+		c.KeyFile = resolve(u.User.ClientKey)
+		if c.CertData, err = decodeData(u.User.ClientCertificateData); err != nil {
+			return nil, err
+		}
+		if c.KeyData, err = decodeData(u.User.ClientKeyData); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func decodeData(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("kube: decoding inline certificate data: %v", err)
+	}
+	return b, nil
+}
+
+// tlsConfig builds the TLS settings for the API server connection.
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: c.Insecure}
+
+	ca := c.CAData
+	if c.CAFile != "" {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ca = b
+	}
+	if len(ca) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("kube: no certificates found in CA bundle")
+		}
+		tc.RootCAs = pool
+	}
+
+	cert, key := c.CertData, c.KeyData
+	if c.CertFile != "" {
+		b, err := ioutil.ReadFile(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		cert = b
+	}
+	if c.KeyFile != "" {
+		b, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = b
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{pair}
+	}
+	return tc, nil
+}
+
+// authorize adds the configured credentials to req.
+func (c *Config) authorize(req *http.Request) error {
+	token := c.BearerToken
+	if c.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package kube
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sampleKubeconfig = `apiVersion: v1
+kind: Config
+current-context: token
+clusters:
+- name: prod
+  cluster:
+    server: https://10.0.0.1:6443
+    certificate-authority-data: Y2EgZGF0YQ==
+- name: staging
+  cluster:
+    server: https://staging.example.com
+    certificate-authority: certs/ca.crt
+    insecure-skip-tls-verify: true
+users:
+- name: token
+  user:
+    token: secret
+- name: cert
+  user:
+    client-certificate: certs/client.crt
+    client-key: /etc/keys/client.key
+    tokenFile: token
+- name: inline
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+- name: broken
+  user:
+    client-key-data: "not base64!"
+contexts:
+- name: token
+  context:
+    cluster: prod
+    user: token
+    namespace: fastdfs
+- name: cert
+  context:
+    cluster: staging
+    user: cert
+- name: inline
+  context:
+    cluster: prod
+    user: inline
+- name: broken
+  context:
+    cluster: prod
+    user: broken
+- name: nocluster
+  context:
+    cluster: gone
+    user: token
+`
+
+func writeKubeconfig(t *testing.T) (dir, path string) {
+	dir, err := ioutil.TempDir("", "kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(sampleKubeconfig), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir, path
+}
+
+func TestLoadKubeconfig(t *testing.T) {
+	dir, path := writeKubeconfig(t)
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		context string
+		want    Config
+	}{
+		// The current context.
+		{"", Config{
+			Host:        "https://10.0.0.1:6443",
+			BearerToken: "secret",
+			CAData:      []byte("ca data"),
+			Namespace:   "fastdfs",
+		}},
+		// File references are resolved against the kubeconfig's directory.
+		{"cert", Config{
+			Host:            "https://staging.example.com",
+			BearerTokenFile: filepath.Join(dir, "token"),
+			CAFile:          filepath.Join(dir, "certs/ca.crt"),
+			CertFile:        filepath.Join(dir, "certs/client.crt"),
+			KeyFile:         "/etc/keys/client.key",
+			Insecure:        true,
+		}},
+		{"inline", Config{
+			Host:     "https://10.0.0.1:6443",
+			CAData:   []byte("ca data"),
+			CertData: []byte("cert"),
+			KeyData:  []byte("key"),
+		}},
+	} {
+		got, err := LoadKubeconfig(path, c.context)
+		if err != nil {
+			t.Errorf("context %q: %v", c.context, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("context %q: got\n%+v\nwant\n%+v", c.context, *got, c.want)
+		}
+	}
+
+	for _, context := range []string{"missing", "nocluster", "broken"} {
+		if _, err := LoadKubeconfig(path, context); err == nil {
+			t.Errorf("context %q loaded", context)
+		}
+	}
+}
+
+func TestAuthorizeRereadsTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+	c := &Config{BearerToken: "ignored", BearerTokenFile: path}
+
+	for _, token := range []string{"first", "rotated"} {
+		if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", "https://10.0.0.1:6443/api", nil)
+		if err := c.authorize(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer "+token {
+			t.Errorf("got Authorization %q, want the token %q", got, token)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "https://10.0.0.1:6443/api", nil)
+	if err := (&Config{Username: "admin", Password: "pw"}).authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	if user, pw, ok := req.BasicAuth(); !ok || user != "admin" || pw != "pw" {
+		t.Errorf("got basic auth %q %q %v", user, pw, ok)
+	}
+}
+
+func TestTLSConfigRejectsBadCA(t *testing.T) {
+	if _, err := (&Config{CAData: []byte("ca data")}).tlsConfig(); err == nil {
+		t.Error("tlsConfig accepted a CA bundle without certificates")
+	}
+}
@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// execProtocol is the channel protocol of the exec endpoint. Every message
+// starts with a byte naming the stream: 1 stdout, 2 stderr, 3 the final
+// Status of the command.
+const execProtocol = "v4.channel.k8s.io"
+
+const (
+	channelStdout = 1
+	channelStderr = 2
+	channelError  = 3
+)
+
+// WebSocket opcodes, RFC 6455 section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// maxFrameSize bounds a single frame so a broken peer cannot make the
+// exporter allocate without limit.
+const maxFrameSize = 64 << 20
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func websocketKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
+
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// readExecStreams copies the stdout and stderr channels of an exec session
+// until the server closes it, and returns the Status sent on the error
+// channel if any.
+func readExecStreams(conn io.ReadWriter, stdout, stderr io.Writer) (*Status, error) {
+	var (
+		status  *Status
+		message []byte
+	)
+	for {
+		fin, op, payload, err := readFrame(conn)
+		if err == io.EOF {
+			return status, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := writeFrame(conn, opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			writeFrame(conn, opClose, payload)
+			return status, nil
+		case opText, opBinary:
+			message = payload
+		case opContinuation:
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("kube: unexpected WebSocket opcode %d", op)
+		}
+		if !fin || len(message) == 0 {
+			continue
+		}
+		data := message[1:]
+		switch message[0] {
+		case channelStdout:
+			stdout.Write(data)
+		case channelStderr:
+			stderr.Write(data)
+		case channelError:
+			if len(data) > 0 {
+				status = &Status{}
+				if err := json.Unmarshal(data, status); err != nil {
+					return nil, fmt.Errorf("kube: decoding exec status: %v", err)
+				}
+			}
+		}
+		message = nil
+	}
+}
+
+func readFrame(r io.Reader) (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxFrameSize {
+		err = fmt.Errorf("kube: WebSocket frame of %d bytes is too large", length)
+		return
+	}
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame sends a single masked frame, as clients must.
+func writeFrame(w io.Writer, op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, 0x80|127)
+		frame = append(frame, ext[:]...)
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
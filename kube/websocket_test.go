@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// serverFrame returns a frame as the API server sends it: unmasked.
+func serverFrame(fin bool, op byte, payload []byte) []byte {
+	head := op
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(frame, payload...)
+}
+
+// channel returns a message on one of the exec channels.
+func channel(ch byte, data string) []byte {
+	return append([]byte{ch}, data...)
+}
+
+func TestWriteFrameMasks(t *testing.T) {
+	for _, n := range []int{0, 5, 125, 126, 0xffff, 0x10000} {
+		payload := bytes.Repeat([]byte("x"), n)
+		var buf bytes.Buffer
+		if err := writeFrame(&buf, opBinary, payload); err != nil {
+			t.Fatal(err)
+		}
+		frame := buf.Bytes()
+		if frame[0] != 0x80|opBinary || frame[1]&0x80 == 0 {
+			t.Errorf("%d byte frame starts with %#x %#x, want a final masked binary frame", n, frame[0], frame[1])
+		}
+		if n > 0 && bytes.Contains(frame, payload) {
+			t.Errorf("%d byte frame carries the payload unmasked", n)
+		}
+		fin, op, got, err := readFrame(&buf)
+		if err != nil || !fin || op != opBinary || !bytes.Equal(got, payload) {
+			t.Errorf("%d byte frame read back as fin %v op %d with %d bytes: %v", n, fin, op, len(got), err)
+		}
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	frame := []byte{0x80 | opBinary, 127, 0, 0, 0, 0, 0x10, 0, 0, 0}
+	if _, _, _, err := readFrame(bytes.NewReader(frame)); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("readFrame of a 256 MiB frame: %v", err)
+	}
+}
+
+// session is a connection to an exec endpoint: what the server sends is
+// read from in, what the client writes collects in out.
+type session struct {
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (s *session) Read(p []byte) (int, error)  { return s.in.Read(p) }
+func (s *session) Write(p []byte) (int, error) { return s.out.Write(p) }
+
+func TestReadExecStreams(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(serverFrame(false, opBinary, channel(channelStdout, "hel")))
+	in.Write(serverFrame(true, opPing, []byte("are you there")))
+	in.Write(serverFrame(true, opContinuation, []byte("lo\n")))
+	in.Write(serverFrame(true, opBinary, channel(channelStderr, "warning")))
+	in.Write(serverFrame(true, opBinary, channel(channelStdout, "world\n")))
+	in.Write(serverFrame(true, opBinary, channel(channelError, `{"status": "Success"}`)))
+	in.Write(serverFrame(true, opClose, []byte{0x03, 0xe8}))
+	conn := &session{in: &in}
+
+	var stdout, stderr bytes.Buffer
+	status, err := readExecStreams(conn, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "hello\nworld\n" || stderr.String() != "warning" {
+		t.Errorf("got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+	if status == nil || status.Status != "Success" {
+		t.Errorf("got status %+v, want Success", status)
+	}
+
+	// The ping is answered and the close echoed, both masked.
+	for _, want := range []struct {
+		op      byte
+		payload string
+	}{{opPong, "are you there"}, {opClose, "\x03\xe8"}} {
+		raw := conn.out.Bytes()
+		if len(raw) < 2 || raw[1]&0x80 == 0 {
+			t.Fatalf("client frame %d is not masked", want.op)
+		}
+		_, op, payload, err := readFrame(&conn.out)
+		if err != nil || op != want.op || string(payload) != want.payload {
+			t.Errorf("client sent op %d %q (%v), want op %d %q", op, payload, err, want.op, want.payload)
+		}
+	}
+}
+
+func TestReadExecStreamsFailure(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(serverFrame(true, opBinary, channel(channelError, `{"status": "Failure", "message": "command terminated with non-zero exit code", "reason": "NonZeroExitCode"}`)))
+	status, err := readExecStreams(&session{in: &in}, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Status != "Failure" || status.Reason != "NonZeroExitCode" {
+		t.Errorf("got status %+v, want the failure", status)
+	}
+
+	in.Reset()
+	in.Write(serverFrame(true, opBinary, channel(channelError, `{"status": `)))
+	if _, err := readExecStreams(&session{in: &in}, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("readExecStreams accepted a truncated status")
+	}
+}
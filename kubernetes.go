@@ -0,0 +1,53 @@
+// kubernetes.go
+package main
+
+import (
+	"os"
+
+	"github.com/ruanchen/fastdfs-exporter/kube"
+)
+
+const defaultApiserverAddress = "http://localhost:8080"
+
+// newKubeConfig picks the API server credentials. A kubeconfig file wins,
+// then an explicit APISERVER, then the service account of the pod the
+// exporter runs in, and finally the local insecure port. Explicit token and
+// certificate settings override what was found.
+func newKubeConfig(config FastDFSConfig) (*kube.Config, error) {
+	var (
+		c   *kube.Config
+		err error
+	)
+	switch {
+	case config.Kubeconfig != "":
+		c, err = kube.LoadKubeconfig(config.Kubeconfig, config.KubeContext)
+	case config.ApiserverAddress != "":
+		c = &kube.Config{Host: config.ApiserverAddress}
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		c, err = kube.InClusterConfig()
+	default:
+		c = &kube.Config{Host: defaultApiserverAddress}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.BearerToken != "" {
+		c.BearerToken, c.BearerTokenFile = config.BearerToken, ""
+	}
+	if config.BearerTokenFile != "" {
+		c.BearerToken, c.BearerTokenFile = "", config.BearerTokenFile
+	}
+	if config.CAFile != "" {
+		c.CAFile = config.CAFile
+	}
+	if config.CertFile != "" {
+		c.CertFile = config.CertFile
+	}
+	if config.KeyFile != "" {
+		c.KeyFile = config.KeyFile
+	}
+	if config.InsecureSkipTLSVerify {
+		c.Insecure = true
+	}
+	return c, nil
+}
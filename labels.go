@@ -0,0 +1,128 @@
+// labels.go
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// descSpec is what a Desc was built from. A Desc does not expose it, and
+// it is needed to rebuild the Desc with the constant labels of a cluster.
+type descSpec struct {
+	fqName         string
+	help           string
+	variableLabels []string
+	constLabels    prometheus.Labels
+}
+
+// descSpecs holds every Desc created through newDesc. It is only written
+// while the package variables are initialized.
+var descSpecs = map[*prometheus.Desc]descSpec{}
+
+// newDesc is prometheus.NewDesc for the exporter's own metrics, which all
+// need to be created through it so they can carry cluster labels.
+func newDesc(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	d := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	descSpecs[d] = descSpec{fqName, help, variableLabels, constLabels}
+	return d
+}
+
+// checkConstLabels reports whether labels can be added to every metric of
+// the exporter.
+func checkConstLabels(labels map[string]string) error {
+	variable := map[string]bool{}
+	for _, spec := range descSpecs {
+		for _, l := range spec.variableLabels {
+			variable[l] = true
+		}
+		for l := range spec.constLabels {
+			variable[l] = true
+		}
+	}
+	for name := range labels {
+		if !model.LabelName(name).IsValid() || model.LabelName(name) == model.MetricNameLabel {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if variable[name] {
+			return fmt.Errorf("label %q is already used by the exporter's metrics", name)
+		}
+	}
+	return nil
+}
+
+// labeler adds a fixed set of labels to metrics created from the descs in
+// descSpecs.
+type labeler struct {
+	descs map[*prometheus.Desc]*prometheus.Desc
+	pairs []*dto.LabelPair
+}
+
+// newLabeler returns a labeler adding labels, which must have passed
+// checkConstLabels. It returns nil for no labels.
+func newLabeler(labels map[string]string) *labeler {
+	if len(labels) == 0 {
+		return nil
+	}
+	l := &labeler{descs: make(map[*prometheus.Desc]*prometheus.Desc, len(descSpecs))}
+	for d, spec := range descSpecs {
+		constLabels := prometheus.Labels{}
+		for k, v := range spec.constLabels {
+			constLabels[k] = v
+		}
+		for k, v := range labels {
+			constLabels[k] = v
+		}
+		l.descs[d] = prometheus.NewDesc(spec.fqName, spec.help, spec.variableLabels, constLabels)
+	}
+	for k, v := range labels {
+		l.pairs = append(l.pairs, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+	}
+	return l
+}
+
+func (l *labeler) desc(d *prometheus.Desc) *prometheus.Desc {
+	if ld, ok := l.descs[d]; ok {
+		return ld
+	}
+	return d
+}
+
+// collect runs collect and passes its metrics on to ch with the labels
+// added.
+func (l *labeler) collect(ch chan<- prometheus.Metric, collect func(chan<- prometheus.Metric)) {
+	inner := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for m := range inner {
+			ch <- labeledMetric{Metric: m, desc: l.desc(m.Desc()), pairs: l.pairs}
+		}
+		close(done)
+	}()
+	collect(inner)
+	close(inner)
+	<-done
+}
+
+type labeledMetric struct {
+	prometheus.Metric
+	desc  *prometheus.Desc
+	pairs []*dto.LabelPair
+}
+
+func (m labeledMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m labeledMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Label = append(out.Label, m.pairs...)
+	sort.Sort(prometheus.LabelPairSorter(out.Label))
+	return nil
+}
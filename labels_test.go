@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+)
+
+func TestCheckConstLabels(t *testing.T) {
+	for _, c := range []struct {
+		labels map[string]string
+		ok     bool
+	}{
+		{nil, true},
+		{map[string]string{"cluster": "prod", "env": "prod"}, true},
+		{map[string]string{"group": "group1"}, false},
+		{map[string]string{"stage": "x"}, false},
+		{map[string]string{"__name__": "x"}, false},
+		{map[string]string{"1env": "prod"}, false},
+		{map[string]string{"env-name": "prod"}, false},
+	} {
+		if err := checkConstLabels(c.labels); (err == nil) != c.ok {
+			t.Errorf("checkConstLabels(%v) = %v, want ok %v", c.labels, err, c.ok)
+		}
+	}
+}
+
+func TestLabelerAddsLabels(t *testing.T) {
+	if newLabeler(nil) != nil {
+		t.Error("newLabeler without labels is not nil")
+	}
+
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	tg := &target{
+		config:   FastDFSConfig{Executor: "local", TrackerServers: []string{tracker.Addr}},
+		executor: executor.NewFake(),
+	}
+	e, err := NewExporter(tg, map[string]string{"cluster": "prod", "env": "test"}, allCollectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := e.labeler.desc(up).String(); !strings.Contains(d, `cluster="prod"`) || !strings.Contains(d, `env="test"`) {
+		t.Errorf("labeled desc of up is %s", d)
+	}
+
+	// The pedantic registry checks every metric against its desc.
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(scrape{ctx: context.Background(), collector: e})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for i, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+				if i > 0 && m.Label[i-1].GetName() > l.GetName() {
+					t.Errorf("%s has unsorted labels %v", f.GetName(), m.GetLabel())
+				}
+			}
+			if labels["cluster"] != "prod" || labels["env"] != "test" {
+				t.Errorf("%s has labels %v, want cluster and env added", f.GetName(), labels)
+			}
+			if f.GetName() == "fastdfs_storage_status" && labels["storage_id"] == "s1" && labels["status"] == "ACTIVE" {
+				found = m.GetGauge().GetValue() == 1
+			}
+		}
+	}
+	if !found {
+		t.Error("fastdfs_storage_status lost its own labels")
+	}
+}
@@ -0,0 +1,136 @@
+// liveness.go
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+var (
+	storageProbeSuccess = newStorageDesc(
+		"probe_success",
+		"Whether the storage server accepted a connection and answered ACTIVE_TEST at the last collection.",
+	)
+	storageProbeDuration = newDesc(
+		prometheus.BuildFQName(namespace, "storage", "probe_duration_seconds"),
+		"How long connecting to the storage server and its ACTIVE_TEST round trip took, by phase.",
+		withLabels(storageLabels, "phase"), nil,
+	)
+)
+
+// livenessBuckets suit round trips within a data center.
+var livenessBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// liveness sends ACTIVE_TEST to every storage server of the topology, as
+// the tracker may report a storage ACTIVE while its port is wedged. The
+// round trip times accumulate across collections.
+type liveness struct {
+	mu        sync.Mutex
+	durations map[livenessKey]*histogram
+}
+
+type livenessKey struct {
+	group, id, ip, phase string
+}
+
+// livenessResult is the outcome of probing one storage server.
+type livenessResult struct {
+	storage *Storage
+	connect time.Duration
+	test    time.Duration
+	err     error
+}
+
+func describeLivenessMetrics(ch chan<- *prometheus.Desc) {
+	ch <- storageProbeSuccess
+	ch <- storageProbeDuration
+}
+
+// collect probes the storage servers of cluster concurrently and sends
+// their results to ch.
+func (l *liveness) collect(ctx context.Context, ch chan<- prometheus.Metric, cluster *Cluster, stats *scrapeStats) {
+	start := time.Now()
+	defer stats.observe(stageLiveness, start)
+	ctx, cancel := stageContext(ctx, stageLiveness)
+	defer cancel()
+
+	var results []*livenessResult
+	var wg sync.WaitGroup
+	for _, g := range cluster.Groups {
+		for _, s := range g.Storages {
+			addr, ok := g.StorageAddr(s)
+			if !ok {
+				continue
+			}
+			r := &livenessResult{storage: s}
+			results = append(results, r)
+			wg.Add(1)
+			go func(addr string) {
+				defer wg.Done()
+				r.connect, r.test, r.err = activeTest(ctx, addr)
+				if r.err != nil {
+					log.Errorf("ACTIVE_TEST of storage %s failed: %v", addr, r.err)
+				}
+			}(addr)
+		}
+	}
+	wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.durations == nil {
+		l.durations = map[livenessKey]*histogram{}
+	}
+	current := map[livenessKey]bool{}
+	for _, r := range results {
+		s := r.storage
+		current[livenessKey{s.Group, s.ID, s.IP, "connect"}] = true
+		current[livenessKey{s.Group, s.ID, s.IP, "active_test"}] = true
+		var success float64
+		if r.err == nil {
+			success = 1
+			l.observe(livenessKey{s.Group, s.ID, s.IP, "connect"}, r.connect)
+			l.observe(livenessKey{s.Group, s.ID, s.IP, "active_test"}, r.test)
+		}
+		ch <- prometheus.MustNewConstMetric(
+			storageProbeSuccess, prometheus.GaugeValue, success, s.Group, s.ID, s.IP,
+		)
+	}
+	for k, h := range l.durations {
+		// Storages that left the cluster take their history with them.
+		if !current[k] {
+			delete(l.durations, k)
+			continue
+		}
+		ch <- h.metric(storageProbeDuration, k.group, k.id, k.ip, k.phase)
+	}
+}
+
+func (l *liveness) observe(k livenessKey, d time.Duration) {
+	h := l.durations[k]
+	if h == nil {
+		h = newHistogram(livenessBuckets)
+		l.durations[k] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// activeTest connects to the storage server at addr and sends it
+// ACTIVE_TEST, returning how long each took.
+func activeTest(ctx context.Context, addr string) (connect, test time.Duration, err error) {
+	start := time.Now()
+	storage, err := fdfs.DialStorageContext(ctx, addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer storage.Close()
+	connect = time.Since(start)
+	start = time.Now()
+	err = storage.ActiveTest()
+	return connect, time.Since(start), err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+func newFakeStorage(t *testing.T, group string) *fdfstest.Storage {
+	s, err := fdfstest.NewStorage(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// probed maps the labels of the liveness metrics of the storages of group
+// with ids, one per phase if phases is set, to value. The fake storages all
+// listen on 127.0.0.1.
+func probed(value float64, phases []string, group string, ids ...string) map[string]float64 {
+	m := map[string]float64{}
+	for _, id := range ids {
+		key := "group=" + group + ",ip=127.0.0.1"
+		if phases == nil {
+			m[key+",storage_id="+id] = value
+		}
+		for _, phase := range phases {
+			m[key+",phase="+phase+",storage_id="+id] = value
+		}
+	}
+	return m
+}
+
+func TestLiveness(t *testing.T) {
+	ok := newFakeStorage(t, "group1")
+	defer ok.Close()
+	failing := newFakeStorage(t, "group1")
+	defer failing.Close()
+	failing.Fail(fdfs.FdfsProtoCmdActiveTest, 5) // EIO
+	down := newFakeStorage(t, "group1")
+	down.Close()
+	leaving := newFakeStorage(t, "group2")
+	defer leaving.Close()
+
+	tracker, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	tracker.AddGroup(fdfs.GroupStat{Name: "group1", StorageCount: 3, ActiveCount: 3},
+		ok.Stat("ok"), failing.Stat("failing"), down.Stat("down"))
+	tracker.AddGroup(fdfs.GroupStat{Name: "group2", StorageCount: 1, ActiveCount: 1}, leaving.Stat("leaving"))
+	e := newTrackersExporter(t, []string{"liveness"}, tracker.Addr)
+	phases := []string{"connect", "active_test"}
+
+	metrics := collectAll(context.Background(), e)
+	want := probed(1, nil, "group1", "ok")
+	for k, v := range probed(0, nil, "group1", "failing", "down") {
+		want[k] = v
+	}
+	for k, v := range probed(1, nil, "group2", "leaving") {
+		want[k] = v
+	}
+	if got := values(t, metrics, storageProbeSuccess); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe success = %v, want %v", got, want)
+	}
+	// Only the storages that answered have round trips to report.
+	want = probed(1, phases, "group1", "ok")
+	for k, v := range probed(1, phases, "group2", "leaving") {
+		want[k] = v
+	}
+	if got := values(t, metrics, storageProbeDuration); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe durations = %v, want %v", got, want)
+	}
+
+	// The histograms accumulate, and go away with their storage.
+	tracker.RemoveGroup("group2")
+	metrics = collectAll(context.Background(), e)
+	if got, want := values(t, metrics, storageProbeDuration), probed(2, phases, "group1", "ok"); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe durations after group2 left = %v, want %v", got, want)
+	}
+	if got := values(t, metrics, storageProbeSuccess); len(got) != 3 {
+		t.Errorf("probe success after group2 left = %v, want the 3 storages of group1", got)
+	}
+}
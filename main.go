@@ -2,17 +2,17 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"strconv"
-
 	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -28,19 +28,55 @@ const (
 type FastDFSData struct {
 	configGroupNum   int
 	configStorageNum int
-	groupCount       int
-	waitSyncState    int
-	activeState      int
+	configRead       bool
+	config           ConfigInfoJSON
+	cluster          *Cluster
+	trackers         []trackerScrape
+	allTrackers      bool
+	stats            *scrapeStats
 }
 
 type FastDFSConfig struct {
-	ApiserverAddress string
-	PodName          string
-	NameSpace        string
+	ApiserverAddress          string        `yaml:"apiserver"`
+	Kubeconfig                string        `yaml:"kubeconfig"`
+	KubeContext               string        `yaml:"kube_context"`
+	BearerToken               string        `yaml:"bearer_token"`
+	BearerTokenFile           string        `yaml:"bearer_token_file"`
+	CAFile                    string        `yaml:"ca_file"`
+	CertFile                  string        `yaml:"cert_file"`
+	KeyFile                   string        `yaml:"key_file"`
+	InsecureSkipTLSVerify     bool          `yaml:"insecure_skip_tls_verify"`
+	Executor                  string        `yaml:"executor"`
+	Targets                   []string      `yaml:"targets"`
+	SSHUser                   string        `yaml:"ssh_user"`
+	SSHPort                   int           `yaml:"ssh_port"`
+	SSHIdentityFile           string        `yaml:"ssh_identity_file"`
+	PodName                   string        `yaml:"pod_name"`
+	Container                 string        `yaml:"container"`
+	NameSpace                 string        `yaml:"namespace"`
+	PodSelector               string        `yaml:"pod_selector"`
+	TrackerServers            []string      `yaml:"tracker_servers"`
+	TrackerSelector           string        `yaml:"tracker_selector"`
+	TrackerPort               string        `yaml:"tracker_port"`
+	CanaryInterval            time.Duration `yaml:"canary_interval"`
+	CanaryTimeout             time.Duration `yaml:"canary_timeout"`
+	CanarySize                int           `yaml:"canary_size"`
+	CanaryReplicationDeadline time.Duration `yaml:"canary_replication_deadline"`
+	HTTPProbe                 bool          `yaml:"http_probe"`
+	HTTPProbeFile             string        `yaml:"http_probe_file"`
+	MonitorTimezone           string        `yaml:"monitor_timezone"`
 }
 
 type Exporter struct {
-	podname string
+	target     *target
+	labeler    *labeler
+	collectors map[string]bool
+	cache      cache
+	errors     errorCounter
+	canary     *canary
+	liveness   liveness
+	done       chan struct{}
+	probe      bool
 }
 
 type ConfigInfoJSON struct {
@@ -54,27 +90,27 @@ type ConfigInfoJSON struct {
 
 var (
 	nodeLabels     = []string{"node"}
-	configGroupNum = prometheus.NewDesc(
+	configGroupNum = newDesc(
 		prometheus.BuildFQName(namespace, "", "config_group_count"),
 		"How many group counts were int the config file.",
 		nodeLabels, nil,
 	)
-	configStorageNum = prometheus.NewDesc(
+	configStorageNum = newDesc(
 		prometheus.BuildFQName(namespace, "", "config_storage_num"),
 		"How many storage were up int the config file.",
 		nodeLabels, nil,
 	)
-	groupCount = prometheus.NewDesc(
+	groupCount = newDesc(
 		prometheus.BuildFQName(namespace, "", "group_count"),
-		"How many group counts were up at the last query.",
+		"How many groups the cluster had at the last query.",
 		nodeLabels, nil,
 	)
-	waitSyncState = prometheus.NewDesc(
+	waitSyncState = newDesc(
 		prometheus.BuildFQName(namespace, "", "wait_sync_state"),
 		"How many nodes were on wait_sync_state at the last query.",
 		nodeLabels, nil,
 	)
-	activeState = prometheus.NewDesc(
+	activeState = newDesc(
 		prometheus.BuildFQName(namespace, "", "active_state"),
 		"How many nodes were on active_state at the last query.",
 		nodeLabels, nil,
@@ -82,15 +118,31 @@ var (
 
 	config        FastDFSConfig
 	defaultConfig = FastDFSConfig{
-		ApiserverAddress: "http://localhost:8080",
-		PodName:          "fastdfs",
-		NameSpace:        "default",
+		Executor:                  "kubernetes",
+		PodName:                   "fastdfs",
+		NameSpace:                 "default",
+		TrackerPort:               "22122",
+		CanaryTimeout:             10 * time.Second,
+		CanarySize:                1024,
+		CanaryReplicationDeadline: time.Minute,
 	}
 )
 
-func NewExporter(podname string) (*Exporter, error) {
+// NewExporter returns an exporter collecting from t with the given
+// collectors, adding labels to every metric.
+func NewExporter(t *target, labels map[string]string, collectors []string) (*Exporter, error) {
+	if err := checkConstLabels(labels); err != nil {
+		return nil, err
+	}
+	enabled := map[string]bool{}
+	for _, c := range collectors {
+		enabled[c] = true
+	}
 	return &Exporter{
-		podname: podname,
+		target:     t,
+		labeler:    newLabeler(labels),
+		collectors: enabled,
+		canary:     newCanary(t.config),
 	}, nil
 }
 
@@ -99,121 +151,362 @@ func initConfig() {
 	if apiserver := os.Getenv("APISERVER"); apiserver != "" {
 		config.ApiserverAddress = apiserver
 	}
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		config.Kubeconfig = kubeconfig
+	}
+	if kubeContext := os.Getenv("KUBE_CONTEXT"); kubeContext != "" {
+		config.KubeContext = kubeContext
+	}
+	if token := os.Getenv("KUBE_TOKEN"); token != "" {
+		config.BearerToken = token
+	}
+	if tokenFile := os.Getenv("KUBE_TOKEN_FILE"); tokenFile != "" {
+		config.BearerTokenFile = tokenFile
+	}
+	if caFile := os.Getenv("KUBE_CA_FILE"); caFile != "" {
+		config.CAFile = caFile
+	}
+	if certFile := os.Getenv("KUBE_CERT_FILE"); certFile != "" {
+		config.CertFile = certFile
+	}
+	if keyFile := os.Getenv("KUBE_KEY_FILE"); keyFile != "" {
+		config.KeyFile = keyFile
+	}
+	if insecure := os.Getenv("KUBE_INSECURE_SKIP_TLS_VERIFY"); insecure != "" {
+		config.InsecureSkipTLSVerify, _ = strconv.ParseBool(insecure)
+	}
+	if executor := os.Getenv("EXECUTOR"); executor != "" {
+		config.Executor = executor
+	}
+	if targets := os.Getenv("FASTDFS_TARGETS"); targets != "" {
+		config.Targets = splitList(targets)
+	}
+	if sshUser := os.Getenv("SSH_USER"); sshUser != "" {
+		config.SSHUser = sshUser
+	}
+	if sshPort := os.Getenv("SSH_PORT"); sshPort != "" {
+		config.SSHPort = mustAtoi("SSH_PORT", sshPort)
+	}
+	if sshIdentityFile := os.Getenv("SSH_IDENTITY_FILE"); sshIdentityFile != "" {
+		config.SSHIdentityFile = sshIdentityFile
+	}
 	if podName := os.Getenv("FASTDFS_POD_NAME"); podName != "" {
 		config.PodName = podName
 	}
+	if container := os.Getenv("FASTDFS_CONTAINER"); container != "" {
+		config.Container = container
+	}
 	if nameSpace := os.Getenv("NAMESPACE"); nameSpace != "" {
 		config.NameSpace = nameSpace
 	}
+	if podSelector := os.Getenv("FASTDFS_POD_SELECTOR"); podSelector != "" {
+		config.PodSelector = podSelector
+	}
+	if trackerSelector := os.Getenv("TRACKER_POD_SELECTOR"); trackerSelector != "" {
+		config.TrackerSelector = trackerSelector
+	}
+	if trackerPort := os.Getenv("TRACKER_PORT"); trackerPort != "" {
+		config.TrackerPort = trackerPort
+	}
+	if trackerServer := os.Getenv("TRACKER_SERVER"); trackerServer != "" {
+		config.TrackerServers = splitList(trackerServer)
+	}
+	if canaryInterval := os.Getenv("CANARY_INTERVAL"); canaryInterval != "" {
+		config.CanaryInterval = mustParseDuration("CANARY_INTERVAL", canaryInterval)
+	}
+	if canaryTimeout := os.Getenv("CANARY_TIMEOUT"); canaryTimeout != "" {
+		config.CanaryTimeout = mustParseDuration("CANARY_TIMEOUT", canaryTimeout)
+	}
+	if canarySize := os.Getenv("CANARY_SIZE"); canarySize != "" {
+		config.CanarySize = mustAtoi("CANARY_SIZE", canarySize)
+	}
+	if deadline := os.Getenv("CANARY_REPLICATION_DEADLINE"); deadline != "" {
+		config.CanaryReplicationDeadline = mustParseDuration("CANARY_REPLICATION_DEADLINE", deadline)
+	}
+	if httpProbe := os.Getenv("HTTP_PROBE"); httpProbe != "" {
+		config.HTTPProbe, _ = strconv.ParseBool(httpProbe)
+	}
+	if httpProbeFile := os.Getenv("HTTP_PROBE_FILE"); httpProbeFile != "" {
+		config.HTTPProbeFile = httpProbeFile
+	}
+	if timezone := os.Getenv("MONITOR_TIMEZONE"); timezone != "" {
+		config.MonitorTimezone = timezone
+	}
+}
+
+// mustParseDuration parses the value of the environment variable name,
+// failing startup rather than leaving the setting at zero.
+func mustParseDuration(name, value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return d
+}
+
+// mustAtoi is mustParseDuration for integers.
+func mustAtoi(name, value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return n
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- configGroupNum
-	ch <- configStorageNum
+	if e.labeler == nil {
+		e.describe(ch)
+		return
+	}
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		e.describe(descs)
+		close(descs)
+	}()
+	for d := range descs {
+		ch <- e.labeler.desc(d)
+	}
+}
+
+func (e *Exporter) describe(ch chan<- *prometheus.Desc) {
+	if e.collectors["config"] {
+		ch <- configGroupNum
+		ch <- configStorageNum
+	}
 	ch <- groupCount
 	ch <- waitSyncState
 	ch <- activeState
+	ch <- lastCollection
+	ch <- up
+	ch <- stageDuration
+	if e.probe {
+		ch <- probeErrors
+	} else {
+		ch <- scrapeErrors
+	}
+	if e.collectors["tracker"] {
+		describeTrackerMetrics(ch)
+	}
+	if e.collectors["topology"] {
+		describeTopologyMetrics(ch)
+	}
+	if e.collectors["group"] {
+		describeGroupMetrics(ch)
+	}
+	if e.collectors["storage"] {
+		describeStorageMetrics(ch)
+	}
+	if e.collectors["liveness"] {
+		describeLivenessMetrics(ch)
+	}
+	if e.collectors["config"] {
+		describeDriftMetrics(ch)
+	}
+	if e.canary != nil {
+		describeCanaryMetrics(ch)
+	}
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	fastData := FastDFSData{}
-	parseFastDFSCommand(&fastData)
-	ch <- prometheus.MustNewConstMetric(
-		configGroupNum, prometheus.GaugeValue, float64(fastData.configGroupNum), namespace,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		configStorageNum, prometheus.GaugeValue, float64(fastData.configStorageNum), namespace,
-	)
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is Collect bound to the context of a scrape.
+func (e *Exporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if e.labeler == nil {
+		e.serve(ctx, ch)
+		return
+	}
+	e.labeler.collect(ch, func(ch chan<- prometheus.Metric) {
+		e.serve(ctx, ch)
+	})
+}
+
+// collect queries the cluster and sends its metrics to ch. Its error says
+// whether any data could be fetched at all.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) (*scrapeStats, error) {
+	fastData := FastDFSData{cluster: &Cluster{}, stats: newScrapeStats()}
+	err := e.target.parseFastDFSCommand(ctx, &fastData, e.collectors["config"])
+	cluster := fastData.cluster
+	if e.collectors["config"] && fastData.configRead {
+		ch <- prometheus.MustNewConstMetric(
+			configGroupNum, prometheus.GaugeValue, float64(fastData.configGroupNum), namespace,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			configStorageNum, prometheus.GaugeValue, float64(fastData.configStorageNum), namespace,
+		)
+	}
+	if e.collectors["tracker"] {
+		collectTrackerMetrics(ch, fastData.trackers)
+	}
+	if e.collectors["topology"] {
+		collectTopologyMetrics(ch, fastData.trackers)
+	}
+	// Without a topology every count below would read 0, which looks like
+	// a cluster without active storage servers.
+	if err != nil {
+		return fastData.stats, err
+	}
 	ch <- prometheus.MustNewConstMetric(
-		groupCount, prometheus.GaugeValue, float64(fastData.groupCount), namespace,
+		groupCount, prometheus.GaugeValue, float64(len(cluster.Groups)), namespace,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		activeState, prometheus.GaugeValue, float64(fastData.activeState), namespace,
+		activeState, prometheus.GaugeValue, float64(cluster.CountStatus("ACTIVE")), namespace,
 	)
 	ch <- prometheus.MustNewConstMetric(
-		waitSyncState, prometheus.GaugeValue, float64(fastData.waitSyncState), namespace,
+		waitSyncState, prometheus.GaugeValue, float64(cluster.CountStatus("WAIT_SYNC")), namespace,
 	)
+	if e.collectors["group"] {
+		collectGroupMetrics(ch, cluster)
+	}
+	if e.collectors["storage"] {
+		collectStorageMetrics(ch, cluster)
+	}
+	if e.collectors["config"] && fastData.configRead {
+		collectDriftMetrics(ch, &fastData.config, cluster, fastData.trackers, fastData.allTrackers)
+	}
+	if e.collectors["liveness"] {
+		e.liveness.collect(ctx, ch, cluster, fastData.stats)
+	}
+	state := clusterState{cluster: cluster}
+	if fastData.configRead {
+		state.config = &fastData.config
+	}
+	e.cache.mu.Lock()
+	e.cache.state = state
+	e.cache.mu.Unlock()
+	return fastData.stats, nil
 }
 
-func execFastDFSCommand(fastData *FastDFSData) {
-	stdoutBuffer1 := &bytes.Buffer{}
-	fastdfsExec1 := exec.Command("sh", "groupcount.sh")
-	fastdfsExec1.Stdout = stdoutBuffer1
-	err1 := fastdfsExec1.Run()
-	if err1 != nil {
-		log.Error(err1)
-	}
-	aa, _ := strconv.Atoi(strings.Replace(stdoutBuffer1.String(), "\n", "", -1))
-	fastData.groupCount = aa
-
-	stdoutBuffer2 := &bytes.Buffer{}
-	fastdfsExec2 := exec.Command("sh", "active.sh")
-	fastdfsExec2.Stdout = stdoutBuffer2
-	err2 := fastdfsExec2.Run()
-	if err2 != nil {
-		log.Error(err2)
-	}
-	bb, _ := strconv.Atoi(strings.Replace(stdoutBuffer2.String(), "\n", "", -1))
-	fastData.activeState = bb
-
-	stdoutBuffer3 := &bytes.Buffer{}
-	fastdfsExec3 := exec.Command("sh", "wait.sh")
-	fastdfsExec3.Stdout = stdoutBuffer3
-	err3 := fastdfsExec3.Run()
-	if err3 != nil {
-		log.Error(err3)
-	}
-	cc, _ := strconv.Atoi(strings.Replace(stdoutBuffer3.String(), "\n", "", -1))
-	fastData.waitSyncState = cc
-
-}
-
-func configDataParse(cmdOutBuff io.Reader, fastData *FastDFSData) {
+func configDataParse(cmdOutBuff io.Reader, fastData *FastDFSData) error {
 	var config ConfigInfoJSON
 	b, err := ioutil.ReadAll(cmdOutBuff)
 	if err != nil {
-		log.Error(err)
+		return err
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return err
 	}
-	err = json.Unmarshal(b, &config)
 
 	aa := config.Group_Num
 	bb := config.Storage_Num
 	fastData.configGroupNum = aa
 	fastData.configStorageNum = bb
+	fastData.config = config
+	fastData.configRead = true
+	return nil
 }
 
-func execFastConfigCommand(fastData *FastDFSData) {
-	stdoutBuffer := &bytes.Buffer{}
-	fastdfsExec := exec.Command("kubectl", "-s", config.ApiserverAddress, "exec", config.PodName, "cat", "/etc/fdfs/FastDFS.json", "-n", config.NameSpace)
-	fastdfsExec.Stdout = stdoutBuffer
-	err := fastdfsExec.Run()
+func (t *target) execFastConfigCommand(ctx context.Context, fastData *FastDFSData, target string) error {
+	ctx, cancel := stageContext(ctx, stageConfig)
+	defer cancel()
+	stdout, err := t.executor.Run(ctx, target, "cat", "/etc/fdfs/FastDFS.json")
 	if err != nil {
-		log.Error(err)
+		return err
 	}
-	configDataParse(stdoutBuffer, fastData)
-
+	return configDataParse(bytes.NewReader(stdout), fastData)
 }
 
-func parseFastDFSCommand(fastData *FastDFSData) {
-	log.Infoln("Config ", config)
-	fastdfsExec := exec.Command("kubectl", "-s", config.ApiserverAddress, "exec", config.PodName, "/usr/bin/fdfs_monitor", "/etc/fdfs/storage.conf", "-n", config.NameSpace)
-	outfile, fileerr := os.Create("./out.txt")
-	if fileerr != nil {
-		log.Error(fileerr)
+// fetchFastConfig reads FastDFS.json from the first target that has it.
+func (t *target) fetchFastConfig(ctx context.Context, fastData *FastDFSData, targets []string) {
+	start := time.Now()
+	defer fastData.stats.observe(stageConfig, start)
+	for _, target := range targets {
+		err := t.execFastConfigCommand(ctx, fastData, target)
+		if err == nil {
+			return
+		}
+		log.Errorf("Reading FastDFS.json from %s failed: %v", target, err)
+		fastData.stats.fail(stageConfig, err)
 	}
-	defer outfile.Close()
+}
 
-	stdoutPipe, err := fastdfsExec.StdoutPipe()
-	writer := bufio.NewWriter(outfile)
-	err = fastdfsExec.Start()
+// parseFastDFSCommand fills fastData from the trackers or fdfs_monitor,
+// reading FastDFS.json as well if readConfig is set. It fails when no
+// tracker or target could be queried.
+func (t *target) parseFastDFSCommand(ctx context.Context, fastData *FastDFSData, readConfig bool) error {
+	stats := fastData.stats
+	start := time.Now()
+	dctx, cancel := stageContext(ctx, stageDiscovery)
+	targets, err := t.fastdfsTargets(dctx)
 	if err != nil {
-		log.Error(err)
+		stats.fail(stageDiscovery, err)
 	}
-	io.Copy(writer, stdoutPipe)
-	defer writer.Flush()
-	fastdfsExec.Wait()
-	execFastConfigCommand(fastData)
-	execFastDFSCommand(fastData)
+	trackers, err := t.trackerAddresses(dctx)
+	if err != nil {
+		stats.fail(stageDiscovery, err)
+	}
+	// Tracker pods found by selector are every tracker of the cluster.
+	fastData.allTrackers = t.config.TrackerSelector != "" && err == nil
+	cancel()
+	stats.observe(stageDiscovery, start)
+	if len(trackers) > 0 {
+		start := time.Now()
+		tctx, cancel := stageContext(ctx, stageTracker)
+		fastData.trackers = scrapeTrackers(tctx, trackers)
+		cancel()
+		stats.observe(stageTracker, start)
+		// Fail over to the next tracker in configuration order.
+		var found bool
+		for _, ts := range fastData.trackers {
+			if ts.err != nil {
+				log.Errorf("Querying tracker %s failed: %v", ts.addr, ts.err)
+				stats.fail(stageTracker, ts.err)
+				continue
+			}
+			if !found {
+				fastData.cluster = ts.cluster
+				found = true
+			}
+		}
+		if readConfig {
+			t.fetchFastConfig(ctx, fastData, targets)
+		}
+		if !found {
+			return fmt.Errorf("no tracker of %s could be queried", strings.Join(trackers, ", "))
+		}
+		return nil
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no FastDFS targets found")
+	}
+	for i, target := range targets {
+		start := time.Now()
+		mctx, cancel := stageContext(ctx, stageMonitor)
+		stdout, err := t.executor.Run(mctx, target, "/usr/bin/fdfs_monitor", "/etc/fdfs/storage.conf")
+		cancel()
+		stats.observe(stageMonitor, start)
+		if err != nil {
+			log.Errorf("Running fdfs_monitor on %s failed: %v", target, err)
+			stats.fail(stageMonitor, err)
+			continue
+		}
+		start = time.Now()
+		cluster, err := parseMonitorOutput(bytes.NewReader(stdout), t.location)
+		stats.observe(stageParse, start)
+		if err != nil {
+			log.Error(err)
+			stats.fail(stageParse, err)
+			continue
+		}
+		fastData.cluster = cluster
+		if readConfig {
+			t.fetchFastConfig(ctx, fastData, targets[i:])
+		}
+		return nil
+	}
+	return fmt.Errorf("fdfs_monitor failed on every target of %s", strings.Join(targets, ", "))
 }
 
 func init() {
@@ -224,7 +517,19 @@ func main() {
 
 	var (
 		listenAddress = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":10000").String()
-		num           int
+		configFile    = kingpin.Flag("config.file", "Path to the file defining the clusters and /probe modules.").String()
+		interval      = kingpin.Flag("collection.interval", "Collect from the clusters in the background at this interval and serve scrapes from the last collection; 0 collects on every scrape.").Default("0s").Duration()
+		maxAge        = kingpin.Flag("collection.max-age", "With the serve stale policy, stop serving metrics older than this once collection fails; 0 serves them until it succeeds again.").Default("0s").Duration()
+		stalePolicy   = kingpin.Flag("collection.stale-policy", "What to do with the last metrics when background collection fails: serve or drop.").Default("serve").String()
+		offset        = kingpin.Flag("timeout-offset", "Offset to subtract from the timeout Prometheus sends with a scrape.").Default("0.5s").Duration()
+		timeouts      = map[string]*time.Duration{
+			stageDiscovery: kingpin.Flag("timeout.discovery", "Timeout for resolving the pod and tracker selectors.").Default("10s").Duration(),
+			stageTracker:   kingpin.Flag("timeout.tracker", "Timeout for querying the trackers.").Default("10s").Duration(),
+			stageMonitor:   kingpin.Flag("timeout.monitor", "Timeout for each fdfs_monitor run.").Default("30s").Duration(),
+			stageConfig:    kingpin.Flag("timeout.config", "Timeout for each read of FastDFS.json.").Default("10s").Duration(),
+			stageLiveness:  kingpin.Flag("timeout.liveness", "Timeout for sending ACTIVE_TEST to the storage servers.").Default("5s").Duration(),
+		}
+		num int
 	)
 	initConfig()
 	log.AddFlags(kingpin.CommandLine)
@@ -235,19 +540,46 @@ func main() {
 	log.Infoln("Starting fastdfs_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	exporter, err := NewExporter("fastdfs")
-	if err != nil {
-		log.Errorf("Creating new Exporter went wrong, ... \n%v", err)
+	timeoutOffset = *offset
+	for stage, d := range timeouts {
+		stageTimeouts[stage] = *d
+	}
+	collection = collectionConfig{interval: *interval, maxAge: *maxAge, stalePolicy: *stalePolicy}
+	if err := collection.validate(); err != nil {
+		log.Fatal(err)
 	}
-	prometheus.MustRegister(exporter)
 
-	http.Handle("/metrics", promhttp.Handler())
+	defaultTarget, err := newTarget(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := &reloader{path: *configFile, defaultTarget: defaultTarget}
+	if err := r.reload(); err != nil {
+		log.Fatalf("Loading %s failed: %v", *configFile, err)
+	}
+	go r.watchSignals()
+
+	// The clusters are collected through a registry per scrape, which binds
+	// the collection to the scrape's deadline.
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ctx, cancel := scrapeContext(req)
+			defer cancel()
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(scrape{ctx: ctx, collector: &r.clusters})
+			gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+			promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+		}),
+	))
+	http.Handle("/probe", &r.prober)
+	http.Handle("/-/reload", r)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		num, err = w.Write([]byte(`<html>
 			<head><title>FastDFS Exporter` + version.Version + `</title></head>
 			<body>
 			<h1>FastDFS Exporter v` + version.Version + `</h1>
 			<p><a href='` + "/metrics" + `'>Metrics</a></p>
+			<p><a href='` + "/probe?target=fastdfs" + `'>Probe</a></p>
 			</body>
 			</html>`))
 		if err != nil {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+)
+
+var (
+	monitorCommand = []string{"/usr/bin/fdfs_monitor", "/etc/fdfs/storage.conf"}
+	configCommand  = []string{"cat", "/etc/fdfs/FastDFS.json"}
+)
+
+// newFakeTarget returns a target running its commands on the given targets
+// through a Fake.
+func newFakeTarget(targets ...string) (*target, *executor.Fake) {
+	fake := executor.NewFake()
+	return &target{
+		config:   FastDFSConfig{Executor: "docker", Targets: targets},
+		executor: fake,
+	}, fake
+}
+
+func readFixture(t *testing.T) []byte {
+	b, err := ioutil.ReadFile("testdata/fdfs_monitor.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func call(target string, command []string) string {
+	return target + ": " + strings.Join(command, " ")
+}
+
+func TestParseFastDFSCommandFailover(t *testing.T) {
+	tg, fake := newFakeTarget("a", "b", "c")
+	fake.Set("a", monitorCommand, nil, &executor.CommandError{Err: errors.New("exit status 1")})
+	fake.Set("b", monitorCommand, readFixture(t), nil)
+	fake.Set("b", configCommand, nil, &executor.CommandError{Err: errors.New("exit status 1"), Stderr: "cat: /etc/fdfs/FastDFS.json: No such file or directory"})
+	fake.Set("c", configCommand, []byte(`{"group_Num": 2, "storage_Num": 3}`), nil)
+
+	fastData := FastDFSData{cluster: &Cluster{}, stats: newScrapeStats()}
+	if err := tg.parseFastDFSCommand(context.Background(), &fastData, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(fastData.cluster.Groups) != 2 {
+		t.Errorf("got %d groups, want the 2 printed on b", len(fastData.cluster.Groups))
+	}
+	if !fastData.configRead || fastData.configGroupNum != 2 || fastData.configStorageNum != 3 {
+		t.Errorf("config read %v with %d groups and %d storages, want FastDFS.json from c",
+			fastData.configRead, fastData.configGroupNum, fastData.configStorageNum)
+	}
+
+	// FastDFS.json is looked for from the target fdfs_monitor succeeded on,
+	// never on the ones before it.
+	want := []string{
+		call("a", monitorCommand),
+		call("b", monitorCommand),
+		call("b", configCommand),
+		call("c", configCommand),
+	}
+	if got := fake.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls\n%q\nwant\n%q", got, want)
+	}
+
+	wantErrors := []stageError{
+		{stageMonitor, "command_failed"},
+		{stageConfig, "not_found"},
+	}
+	if got := fastData.stats.errors; !reflect.DeepEqual(got, wantErrors) {
+		t.Errorf("got errors %v, want %v", got, wantErrors)
+	}
+	for _, stage := range []string{stageDiscovery, stageMonitor, stageParse, stageConfig} {
+		if _, ok := fastData.stats.durations[stage]; !ok {
+			t.Errorf("no duration recorded for stage %s", stage)
+		}
+	}
+}
+
+func TestParseFastDFSCommandAllTargetsFail(t *testing.T) {
+	tg, fake := newFakeTarget("a", "b")
+	fake.Set("a", monitorCommand, nil, context.DeadlineExceeded)
+	// A line longer than the scanner accepts fails the parse.
+	fake.Set("b", monitorCommand, []byte(strings.Repeat("x", 1<<17)), nil)
+	fake.Set("b", configCommand, []byte(`{"group_Num": 2}`), nil)
+
+	fastData := FastDFSData{cluster: &Cluster{}, stats: newScrapeStats()}
+	if err := tg.parseFastDFSCommand(context.Background(), &fastData, true); err == nil {
+		t.Fatal("parseFastDFSCommand succeeded without a usable target")
+	}
+	if fastData.configRead {
+		t.Error("FastDFS.json was read although no target had a topology")
+	}
+	wantErrors := []stageError{
+		{stageMonitor, "timeout"},
+		{stageParse, "parse_error"},
+	}
+	if got := fastData.stats.errors; !reflect.DeepEqual(got, wantErrors) {
+		t.Errorf("got errors %v, want %v", got, wantErrors)
+	}
+	want := []string{call("a", monitorCommand), call("b", monitorCommand)}
+	if got := fake.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got calls\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCollectCountsErrorsByStage(t *testing.T) {
+	tg, fake := newFakeTarget("a", "b")
+	fake.Set("a", monitorCommand, nil, &executor.CommandError{Err: errors.New("exit status 1"), Stderr: "Error: No such container: a"})
+	fake.Set("b", monitorCommand, readFixture(t), nil)
+	fake.Set("b", configCommand, []byte(`{"group_Num": 2, "storage_Num": 3}`), nil)
+	e, err := NewExporter(tg, nil, []string{"config", "group", "storage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		metrics := collectAll(context.Background(), e)
+		if v, ok := gaugeValue(t, metrics, up); !ok || v != 1 {
+			t.Errorf("collection %d: up = %v (found %v), want 1 after failing over", i, v, ok)
+		}
+		errs := map[string]float64{}
+		for _, m := range metrics {
+			if m.Desc() != scrapeErrors {
+				continue
+			}
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			labels := map[string]string{}
+			for _, l := range pb.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			errs[labels["stage"]+" "+labels["cause"]] = pb.GetCounter().GetValue()
+		}
+		if want := map[string]float64{"monitor not_found": float64(i)}; !reflect.DeepEqual(errs, want) {
+			t.Errorf("collection %d: got errors %v, want %v", i, errs, want)
+		}
+	}
+}
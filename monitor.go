@@ -0,0 +1,320 @@
+// monitor.go
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	monitorTimeLayout = "2006-01-02 15:04:05"
+	bytesPerMB        = 1024 * 1024
+	// maxZoneOffset bounds the UTC offsets in use, in seconds.
+	maxZoneOffset = 14 * 60 * 60
+)
+
+// Fields holds the "key = value" pairs fdfs_monitor prints for a group or a
+// storage server, keyed exactly as printed.
+type Fields map[string]string
+
+// Cluster is the topology reported by one fdfs_monitor run. Time is when the
+// report was produced, by the clock of the host that ran fdfs_monitor.
+type Cluster struct {
+	Time               time.Time
+	TrackerServerCount int
+	Trackers           []string
+	GroupCount         int
+	Groups             []*Group
+}
+
+// Group is one "Group N:" section of the fdfs_monitor output.
+type Group struct {
+	Name     string
+	Fields   Fields
+	Storages []*Storage
+}
+
+// Storage is one "Storage N:" section nested in a group. Location is the
+// time zone the times in Fields are printed in.
+type Storage struct {
+	Group    string
+	ID       string
+	IP       string
+	Hostname string
+	Status   string
+	Fields   Fields
+	Location *time.Location
+}
+
+// Int returns the leading integer of the value stored under key, so that
+// "51175 MB" yields 51175. Newer fdfs_monitor builds group thousands with
+// commas, as in "102,350 MB", which are skipped.
+func (f Fields) Int(key string) (int64, bool) {
+	v := strings.Fields(f[key])
+	if len(v) == 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.Replace(v[0], ",", "", -1), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Bytes returns the value stored under key in bytes. FastDFS prints space in
+// MB, so values carrying an "MB" unit are scaled accordingly.
+func (f Fields) Bytes(key string) (int64, bool) {
+	n, ok := f.Int(key)
+	if !ok {
+		return 0, false
+	}
+	if v := strings.Fields(f[key]); len(v) > 1 && v[1] == "MB" {
+		n *= bytesPerMB
+	}
+	return n, true
+}
+
+// Time returns the timestamp stored under key, printed in loc. fdfs_monitor
+// prints times in the time zone of the host it runs on, optionally followed
+// by a remark such as "(never synced)". A zero timestamp is printed as the
+// epoch in that time zone, so anything before 1971 is returned as the Unix
+// epoch.
+func (f Fields) Time(key string, loc *time.Location) (time.Time, bool) {
+	v := strings.TrimSpace(f[key])
+	if len(v) < len(monitorTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(monitorTimeLayout, v[:len(monitorTimeLayout)], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if t.Year() < 1971 {
+		return time.Unix(0, 0), true
+	}
+	return t, true
+}
+
+// Time returns the timestamp stored under key in the fields of s.
+func (s *Storage) Time(key string) (time.Time, bool) {
+	return s.Fields.Time(key, s.Location)
+}
+
+// Storages returns every storage server of every group.
+func (c *Cluster) Storages() []*Storage {
+	var storages []*Storage
+	for _, g := range c.Groups {
+		storages = append(storages, g.Storages...)
+	}
+	return storages
+}
+
+// CountStatus returns how many storage servers are in the given status.
+func (c *Cluster) CountStatus(status string) int {
+	n := 0
+	for _, s := range c.Storages() {
+		if s.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// StorageAddr returns the host:port clients reach s on, taking the port
+// from s or else from its group. It reports false when neither has one.
+func (g *Group) StorageAddr(s *Storage) (string, bool) {
+	return g.addr(s, "storage_port", "storage server port")
+}
+
+// StorageHTTPAddr is StorageAddr for the HTTP port of s.
+func (g *Group) StorageHTTPAddr(s *Storage) (string, bool) {
+	return g.addr(s, "storage_http_port", "storage HTTP port")
+}
+
+func (g *Group) addr(s *Storage, storageKey, groupKey string) (string, bool) {
+	port, ok := s.Fields.Int(storageKey)
+	if !ok || port == 0 {
+		port, ok = g.Fields.Int(groupKey)
+	}
+	if !ok || port == 0 || s.IP == "" {
+		return "", false
+	}
+	return net.JoinHostPort(s.IP, strconv.FormatInt(port, 10)), true
+}
+
+// SyncDelay returns how far s trails the most recent update any of its group
+// peers received from a client, the same figure fdfs_monitor prints as
+// "(Ns delay)". It reports false while s has never synced.
+func (g *Group) SyncDelay(s *Storage) (time.Duration, bool) {
+	synced, ok := s.Time("last_synced_timestamp")
+	if !ok || synced.Unix() == 0 {
+		return 0, false
+	}
+	var latest time.Time
+	for _, peer := range g.Storages {
+		if peer == s {
+			continue
+		}
+		if t, ok := peer.Time("last_source_update"); ok && t.After(latest) {
+			latest = t
+		}
+	}
+	if !latest.After(synced) {
+		return 0, true
+	}
+	return latest.Sub(synced), true
+}
+
+// Now returns the reporting host's view of the current time, falling back to
+// the local clock when the output carried no timestamp.
+func (c *Cluster) Now() time.Time {
+	if c.Time.IsZero() {
+		return time.Now()
+	}
+	return c.Time
+}
+
+// parseMonitorOutput turns the text printed by fdfs_monitor into a Cluster.
+// Its times are read in loc, or when loc is nil in the time zone the epoch
+// is printed in, falling back to the local time zone when the output has no
+// zero timestamp.
+func parseMonitorOutput(r io.Reader, loc *time.Location) (*Cluster, error) {
+	var (
+		cluster = &Cluster{}
+		group   *Group
+		storage *Storage
+		logTime string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "["):
+			// log lines such as "[2018-03-01 10:00:00] DEBUG - base_path=..."
+			if end := strings.Index(line, "]"); end > 0 && logTime == "" {
+				logTime = line[1:end]
+			}
+		case strings.HasPrefix(line, "server_count="):
+			for _, kv := range strings.Split(line, ",") {
+				kv = strings.TrimSpace(kv)
+				if strings.HasPrefix(kv, "server_count=") {
+					cluster.TrackerServerCount, _ = strconv.Atoi(strings.TrimPrefix(kv, "server_count="))
+				}
+			}
+		case strings.HasPrefix(line, "tracker server is "):
+			cluster.Trackers = append(cluster.Trackers, strings.TrimPrefix(line, "tracker server is "))
+		case strings.HasPrefix(line, "group count:"):
+			cluster.GroupCount, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "group count:")))
+		case isSectionHeader(line, "Group "):
+			group = &Group{Fields: Fields{}}
+			storage = nil
+			cluster.Groups = append(cluster.Groups, group)
+		case isSectionHeader(line, "Storage "):
+			if group == nil {
+				continue
+			}
+			storage = &Storage{Group: group.Name, Fields: Fields{}}
+			group.Storages = append(group.Storages, storage)
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 {
+				continue
+			}
+			key := strings.TrimSpace(line[:i])
+			value := strings.TrimSpace(line[i+1:])
+			switch {
+			case storage != nil:
+				storage.set(key, value)
+			case group != nil:
+				group.Fields[key] = value
+				if key == "group name" {
+					group.Name = value
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = epochLocation(cluster)
+	}
+	for _, s := range cluster.Storages() {
+		s.Location = loc
+	}
+	if t, err := time.ParseInLocation(monitorTimeLayout, logTime, loc); err == nil {
+		cluster.Time = t
+	}
+	return cluster, nil
+}
+
+// epochLocation returns the time zone of the host that printed c, derived
+// from a zero timestamp printed as the epoch in that zone, such as
+// "1970-01-01 08:00:00" in UTC+8. Without one it returns the local time
+// zone.
+func epochLocation(c *Cluster) *time.Location {
+	for _, s := range c.Storages() {
+		for _, v := range s.Fields {
+			if len(v) < len(monitorTimeLayout) || !(strings.HasPrefix(v, "1970-01-01 ") || strings.HasPrefix(v, "1969-12-31 ")) {
+				continue
+			}
+			t, err := time.ParseInLocation(monitorTimeLayout, v[:len(monitorTimeLayout)], time.UTC)
+			if err != nil {
+				continue
+			}
+			// The epoch printed in UTC+8 reads 08:00, eight hours past it
+			// in UTC, so the offset is the parsed time itself.
+			offset := t.Unix()
+			if offset < -maxZoneOffset || offset > maxZoneOffset {
+				continue
+			}
+			return time.FixedZone("", int(offset))
+		}
+	}
+	return time.Local
+}
+
+// parseTimezone returns the time zone named by s, either a fixed offset such
+// as "+08:00" or a name from the time zone database such as
+// "Asia/Shanghai".
+func parseTimezone(s string) (*time.Location, error) {
+	if t, err := time.Parse("-07:00", s); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(s, offset), nil
+	}
+	return time.LoadLocation(s)
+}
+
+// isSectionHeader reports whether line looks like "Group 1:" or "Storage 2:".
+func isSectionHeader(line, prefix string) bool {
+	if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, ":") {
+		return false
+	}
+	_, err := strconv.Atoi(line[len(prefix) : len(line)-1])
+	return err == nil
+}
+
+func (s *Storage) set(key, value string) {
+	s.Fields[key] = value
+	switch key {
+	case "id":
+		s.ID = value
+	case "ip_addr":
+		// "192.168.1.11 (storage-0)  ACTIVE" or "192.168.1.11  ACTIVE"
+		v := strings.Fields(value)
+		if len(v) == 0 {
+			return
+		}
+		s.IP = v[0]
+		if len(v) > 1 {
+			s.Status = v[len(v)-1]
+		}
+		if len(v) > 2 {
+			s.Hostname = strings.Trim(strings.Join(v[1:len(v)-1], " "), "()")
+		}
+	}
+}
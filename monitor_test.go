@@ -0,0 +1,315 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// utc8 is the zone of the hosts that printed testdata/fdfs_monitor.txt.
+var utc8 = time.FixedZone("", 8*60*60)
+
+func parseMonitorFile(t *testing.T, path string, loc *time.Location) *Cluster {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cluster, err := parseMonitorOutput(f, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+func TestParseMonitorOutput(t *testing.T) {
+	cluster := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil)
+
+	if cluster.TrackerServerCount != 2 {
+		t.Errorf("TrackerServerCount = %d, want 2", cluster.TrackerServerCount)
+	}
+	if len(cluster.Trackers) != 1 || cluster.Trackers[0] != "192.168.1.10:22122" {
+		t.Errorf("Trackers = %q, want [192.168.1.10:22122]", cluster.Trackers)
+	}
+	if cluster.GroupCount != 2 || len(cluster.Groups) != 2 {
+		t.Fatalf("got group count %d and %d groups, want 2 of each", cluster.GroupCount, len(cluster.Groups))
+	}
+	if want := time.Date(2018, 3, 1, 10, 0, 0, 0, utc8); !cluster.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", cluster.Time, want)
+	}
+	if got := cluster.CountStatus("ACTIVE"); got != 2 {
+		t.Errorf("CountStatus(ACTIVE) = %d, want 2", got)
+	}
+
+	group1, group2 := cluster.Groups[0], cluster.Groups[1]
+	if group1.Name != "group1" || group2.Name != "group2" {
+		t.Errorf("group names are %q and %q, want group1 and group2", group1.Name, group2.Name)
+	}
+	if free, _ := group1.Fields.Bytes("disk free space"); free != 85310*bytesPerMB {
+		t.Errorf("group1 disk free space = %d bytes, want %d", free, 85310*bytesPerMB)
+	}
+	if len(group1.Storages) != 2 || len(group2.Storages) != 1 {
+		t.Fatalf("groups have %d and %d storages, want 2 and 1", len(group1.Storages), len(group2.Storages))
+	}
+
+	for _, c := range []struct {
+		storage                         *Storage
+		group, id, ip, hostname, status string
+	}{
+		{group1.Storages[0], "group1", "192.168.1.11", "192.168.1.11", "storage-0", "ACTIVE"},
+		{group1.Storages[1], "group1", "192.168.1.12", "192.168.1.12", "", "ACTIVE"},
+		{group2.Storages[0], "group2", "192.168.1.13", "192.168.1.13", "storage-2", "OFFLINE"},
+	} {
+		s := c.storage
+		if s.Group != c.group || s.ID != c.id || s.IP != c.ip || s.Hostname != c.hostname || s.Status != c.status {
+			t.Errorf("got storage %q/%q ip %q hostname %q status %q, want %q/%q ip %q hostname %q status %q",
+				s.Group, s.ID, s.IP, s.Hostname, s.Status, c.group, c.id, c.ip, c.hostname, c.status)
+		}
+	}
+	if addr, ok := group1.StorageAddr(group1.Storages[0]); !ok || addr != "192.168.1.11:23000" {
+		t.Errorf("StorageAddr = %q, %v, want 192.168.1.11:23000", addr, ok)
+	}
+}
+
+func TestParseMonitorOutputTimes(t *testing.T) {
+	cluster := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil)
+	s1, s2 := cluster.Groups[0].Storages[0], cluster.Groups[0].Storages[1]
+
+	// "(never synced)" follows a zero timestamp printed as the epoch.
+	if synced, ok := s1.Time("last_synced_timestamp"); !ok || synced.Unix() != 0 {
+		t.Errorf("never synced timestamp = %v, %v, want the epoch", synced, ok)
+	}
+	// "(5s delay)" follows a real timestamp.
+	want := time.Date(2018, 3, 1, 9, 57, 55, 0, utc8)
+	if synced, ok := s2.Time("last_synced_timestamp"); !ok || !synced.Equal(want) {
+		t.Errorf("synced timestamp = %v, %v, want %v", synced, ok, want)
+	}
+	if _, ok := cluster.Groups[0].SyncDelay(s1); ok {
+		t.Error("SyncDelay reported a delay for a storage that never synced")
+	}
+	if delay, ok := cluster.Groups[0].SyncDelay(s2); !ok || delay != 5*time.Second {
+		t.Errorf("SyncDelay = %v, %v, want 5s as printed by fdfs_monitor", delay, ok)
+	}
+
+	// s1 updated a file at 09:58:00 and last beat at 09:59:50.
+	if skew, ok := clockSkew(s1); !ok || skew != -110*time.Second {
+		t.Errorf("clockSkew = %v, %v, want -1m50s", skew, ok)
+	}
+	if _, ok := clockSkew(cluster.Groups[1].Storages[0]); ok {
+		t.Error("clockSkew reported a skew for a storage that never had an update")
+	}
+}
+
+func TestParseMonitorOutputTimezone(t *testing.T) {
+	cluster := parseMonitorFile(t, "testdata/fdfs_monitor.txt", time.UTC)
+	if want := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC); !cluster.Time.Equal(want) {
+		t.Errorf("Time with a configured zone = %v, want %v", cluster.Time, want)
+	}
+
+	// Without a zero timestamp nothing tells the zone, and the local one is
+	// assumed.
+	cluster, err := parseMonitorOutput(strings.NewReader(`
+Group 1:
+group name = group1
+
+	Storage 1:
+		id = 192.168.1.11
+		ip_addr = 192.168.1.11  ACTIVE
+		last_heart_beat_time = 2018-03-01 09:59:50
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beat, _ := cluster.Groups[0].Storages[0].Time("last_heart_beat_time")
+	if want := time.Date(2018, 3, 1, 9, 59, 50, 0, time.Local); !beat.Equal(want) {
+		t.Errorf("heartbeat without a known zone = %v, want %v", beat, want)
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		offset int
+	}{
+		{"+08:00", 8 * 60 * 60},
+		{"-05:30", -(5*60 + 30) * 60},
+		{"UTC", 0},
+	} {
+		loc, err := parseTimezone(c.in)
+		if err != nil {
+			t.Errorf("parseTimezone(%q): %v", c.in, err)
+			continue
+		}
+		if _, offset := time.Unix(0, 0).In(loc).Zone(); offset != c.offset {
+			t.Errorf("parseTimezone(%q) has offset %d, want %d", c.in, offset, c.offset)
+		}
+	}
+	if _, err := parseTimezone("Not/AZone"); err == nil {
+		t.Error("parseTimezone accepted an unknown zone")
+	}
+}
+
+func TestParseMonitorOutputStorageBeforeGroup(t *testing.T) {
+	cluster, err := parseMonitorOutput(strings.NewReader(`
+group count: 1
+
+	Storage 1:
+		id = 192.168.1.99
+		ip_addr = 192.168.1.99  ACTIVE
+
+Group 1:
+group name = group1
+
+	Storage 1:
+		id = 192.168.1.11
+		ip_addr = 192.168.1.11  ACTIVE
+`), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storages := cluster.Storages()
+	if len(storages) != 1 || storages[0].ID != "192.168.1.11" {
+		t.Fatalf("got storages %+v, want only 192.168.1.11", storages)
+	}
+	if len(cluster.Groups[0].Fields) != 1 {
+		t.Errorf("group fields = %v, want only the group name", cluster.Groups[0].Fields)
+	}
+}
+
+// fdfs_monitor prints total_download_bytes as stotal_download_bytes.
+func TestCollectStorageMetricsDownloadBytes(t *testing.T) {
+	cluster := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil)
+	var download *prometheus.Desc
+	for _, c := range storageByteCounters {
+		if c.name == "download" {
+			download = c.desc
+		}
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collectStorageMetrics(ch, &Cluster{Groups: cluster.Groups[:1]})
+		close(ch)
+	}()
+	got := map[string]float64{}
+	for m := range ch {
+		if m.Desc() != download {
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		got[labels["storage_id"]+" "+labels["result"]] = pb.GetCounter().GetValue()
+	}
+	want := map[string]float64{
+		"192.168.1.11 success": 50176,
+		"192.168.1.11 failure": 1024,
+		"192.168.1.12 success": 0,
+		"192.168.1.12 failure": 0,
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			t.Errorf("download bytes %s = %v (exported %v), want %v", k, g, ok, v)
+		}
+	}
+}
+
+func TestCollectStorageMetricsStatus(t *testing.T) {
+	g := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil).Groups[0]
+	// A state the exporter does not know gets a series of its own.
+	g.Storages[1].Status = "NONE"
+	metrics := gatherFunc(func(ch chan<- prometheus.Metric) {
+		collectStorageMetrics(ch, &Cluster{Groups: []*Group{g}})
+	})
+
+	want := map[string]float64{}
+	for _, id := range []string{"192.168.1.11", "192.168.1.12"} {
+		for _, state := range storageStates {
+			want["group=group1,ip="+id+",status="+state+",storage_id="+id] = 0
+		}
+	}
+	want["group=group1,ip=192.168.1.11,status=ACTIVE,storage_id=192.168.1.11"] = 1
+	want["group=group1,ip=192.168.1.12,status=NONE,storage_id=192.168.1.12"] = 1
+	if got := values(t, metrics, storageStatus); !reflect.DeepEqual(got, want) {
+		t.Errorf("storage status = %v, want %v", got, want)
+	}
+}
+
+func TestCollectStorageMetricsOperations(t *testing.T) {
+	g := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil).Groups[0]
+	metrics := gatherFunc(func(ch chan<- prometheus.Metric) {
+		collectStorageMetrics(ch, &Cluster{Groups: []*Group{g}})
+	})
+
+	got := values(t, metrics, storageOperationsTotal)
+	if len(got) != 2*2*len(storageOperations) {
+		t.Errorf("exported %d operation series, want a success and a failure per operation and storage", len(got))
+	}
+	for k, v := range map[string]float64{
+		"group=group1,ip=192.168.1.11,operation=upload,result=success,storage_id=192.168.1.11": 98,
+		"group=group1,ip=192.168.1.11,operation=upload,result=failure,storage_id=192.168.1.11": 2,
+		"group=group1,ip=192.168.1.12,operation=upload,result=success,storage_id=192.168.1.12": 80,
+		"group=group1,ip=192.168.1.12,operation=upload,result=failure,storage_id=192.168.1.12": 0,
+	} {
+		if g, ok := got[k]; !ok || g != v {
+			t.Errorf("operations %s = %v (exported %v), want %v", k, g, ok, v)
+		}
+	}
+}
+
+func TestCollectSuccessFailure(t *testing.T) {
+	split := func(success, failure float64) map[string]float64 {
+		return map[string]float64{
+			"group=group1,ip=10.0.0.1,operation=upload,result=success,storage_id=s1": success,
+			"group=group1,ip=10.0.0.1,operation=upload,result=failure,storage_id=s1": failure,
+		}
+	}
+	for _, c := range []struct {
+		name   string
+		fields Fields
+		want   map[string]float64
+	}{
+		{"total", Fields{"total_upload_count": "10", "success_upload_count": "7"}, split(7, 3)},
+		{"misspelled total", Fields{"stotal_upload_count": "10", "success_upload_count": "10"}, split(10, 0)},
+		{"total wins", Fields{"total_upload_count": "10", "stotal_upload_count": "20", "success_upload_count": "4"}, split(4, 6)},
+		{"no success", Fields{"total_upload_count": "5"}, split(0, 5)},
+		{"no total", Fields{"success_upload_count": "5"}, map[string]float64{}},
+	} {
+		s := &Storage{Group: "group1", ID: "s1", IP: "10.0.0.1", Fields: c.fields}
+		metrics := gatherFunc(func(ch chan<- prometheus.Metric) {
+			collectSuccessFailure(ch, storageOperationsTotal, s, "upload_count", "upload")
+		})
+		if got := values(t, metrics, storageOperationsTotal); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: operations = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCollectGroupMetricsThousandsSeparators(t *testing.T) {
+	cluster := parseMonitorFile(t, "testdata/fdfs_monitor.txt", nil)
+	metrics := gatherFunc(func(ch chan<- prometheus.Metric) {
+		collectGroupMetrics(ch, cluster)
+	})
+	for _, c := range []struct {
+		desc *prometheus.Desc
+		want map[string]float64
+	}{
+		// group2 is printed with thousands separators.
+		{groupMetrics[0].desc, map[string]float64{"group=group1": 102350 * bytesPerMB, "group=group2": 1048576 * bytesPerMB}},
+		{groupMetrics[1].desc, map[string]float64{"group=group1": 85310 * bytesPerMB, "group=group2": 1024000 * bytesPerMB}},
+	} {
+		if got := values(t, metrics, c.desc); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s = %v, want %v", c.desc, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// probe.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+)
+
+type probeModule struct {
+	prober string
+	target *target
+}
+
+// prober serves /probe?target=<tracker or pod>&module=<name>, running a
+// full collection against target with the settings of the module. Without
+// a module the environment configuration and the monitor prober are used.
+type prober struct {
+	mu      sync.RWMutex
+	modules map[string]probeModule
+}
+
+func (p *prober) update(modules map[string]probeModule) {
+	p.mu.Lock()
+	p.modules = modules
+	p.mu.Unlock()
+}
+
+// probe returns a copy of the module's target pointed at addr.
+func (m probeModule) probe(addr string) *target {
+	t := *m.target
+	t.config.TrackerSelector = ""
+	if m.prober == "tracker" {
+		t.config.TrackerServers = []string{addr}
+		return &t
+	}
+	t.config.TrackerServers = nil
+	t.config.PodName = addr
+	t.config.PodSelector = ""
+	t.config.Targets = []string{addr}
+	return &t
+}
+
+var (
+	// dns1123Subdomain matches Kubernetes object names, such as those of pods.
+	dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	// containerName matches the names docker gives and accepts for containers.
+	containerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// checkTarget returns an error unless addr is something the module can
+// probe: a tracker host:port, a pod name, a container name or a host name,
+// depending on the prober and the executor. The target comes from an
+// unauthenticated query and ends up in API paths and command lines, so
+// anything else is refused before it gets there.
+func (m probeModule) checkTarget(addr string) error {
+	if strings.HasPrefix(addr, "-") || strings.Contains(addr, "/") || strings.Contains(addr, "..") {
+		return fmt.Errorf("invalid target %q", addr)
+	}
+	var ok bool
+	switch {
+	case m.prober == "tracker":
+		host, port, err := net.SplitHostPort(addr)
+		n, _ := strconv.Atoi(port)
+		ok = err == nil && validHost(host) && n > 0 && n < 1<<16
+	case m.target.config.Executor == "kubernetes":
+		ok = len(addr) <= 253 && dns1123Subdomain.MatchString(addr)
+	case m.target.config.Executor == "docker":
+		ok = containerName.MatchString(addr)
+	default:
+		ok = validHost(addr)
+	}
+	if !ok {
+		return fmt.Errorf("invalid target %q for the %s prober with the %s executor", addr, m.prober, m.target.config.Executor)
+	}
+	return nil
+}
+
+// validHost reports whether host is an IP address or a DNS name.
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	return len(host) <= 253 && dns1123Subdomain.MatchString(strings.ToLower(host))
+}
+
+// collectors returns the collectors to probe with. FastDFS.json is read from
+// the module's pods, which need not belong to the cluster of a tracker given
+// as target, so tracker probes leave it out.
+func (m probeModule) collectors() []string {
+	if m.prober != "tracker" {
+		return allCollectors
+	}
+	var collectors []string
+	for _, c := range allCollectors {
+		if c != "config" {
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors
+}
+
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("target")
+	if addr == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("module")
+	p.mu.RLock()
+	m, ok := p.modules[name]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", name), http.StatusBadRequest)
+		return
+	}
+	if err := m.checkTarget(addr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exporter, err := NewExporter(m.probe(addr), nil, m.collectors())
+	if err != nil {
+		log.Errorf("Creating new Exporter went wrong, ... \n%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	exporter.probe = true
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(scrape{ctx: ctx, collector: exporter})
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+func probeBody(t *testing.T, p *prober, query string) string {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?"+query, nil))
+	if rec.Code != 200 {
+		t.Fatalf("/probe?%s answered %d: %s", query, rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+func TestProbeTracker(t *testing.T) {
+	storage, err := fdfstest.NewStorage("group1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	tracker, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Close()
+	tracker.AddGroup(fdfs.GroupStat{Name: "group1", StorageCount: 1, ActiveCount: 1}, storage.Stat("s1"))
+
+	// The module's pod holds the FastDFS.json of another cluster.
+	fake := executor.NewFake()
+	fake.Set("fastdfs", configCommand, []byte(`{"group_Num": 5, "storage_Num": 10}`), nil)
+	p := &prober{modules: map[string]probeModule{
+		"tracker": {prober: "tracker", target: &target{
+			config:   FastDFSConfig{Executor: "local", PodName: "fastdfs"},
+			executor: fake,
+		}},
+	}}
+
+	body := probeBody(t, p, "module=tracker&target="+tracker.Addr)
+	if !strings.Contains(body, "\nfastdfs_up 1\n") {
+		t.Errorf("probe of a working tracker is not up:\n%s", body)
+	}
+	for _, name := range []string{"fastdfs_config_", "fastdfs_topology_drift", "fastdfs_scrape_errors_total"} {
+		if strings.Contains(body, name) {
+			t.Errorf("tracker probe exported %s:\n%s", name, body)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("tracker probe ran %q", calls)
+	}
+}
+
+func TestProbeErrorsArePerProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	p := &prober{modules: map[string]probeModule{
+		"tracker": {prober: "tracker", target: &target{
+			config:   FastDFSConfig{Executor: "local"},
+			executor: executor.NewFake(),
+		}},
+	}}
+	want := `fastdfs_probe_errors{cause="unreachable",stage="tracker"} 1`
+	for i := 0; i < 2; i++ {
+		body := probeBody(t, p, "module=tracker&target="+addr)
+		if !strings.Contains(body, "\nfastdfs_up 0\n") || !strings.Contains(body, want) {
+			t.Errorf("probe %d of a closed port does not report %s:\n%s", i+1, want, body)
+		}
+	}
+}
+
+func TestProbeRejectsTargets(t *testing.T) {
+	modules := map[string]probeModule{}
+	for _, name := range []string{"kubernetes", "docker", "ssh"} {
+		modules[name] = probeModule{prober: "monitor", target: &target{
+			config:   FastDFSConfig{Executor: name},
+			executor: executor.NewFake(),
+		}}
+	}
+	modules["tracker"] = probeModule{prober: "tracker", target: &target{
+		config:   FastDFSConfig{Executor: "kubernetes"},
+		executor: executor.NewFake(),
+	}}
+	p := &prober{modules: modules}
+
+	for _, c := range []struct {
+		module, target string
+	}{
+		{"kubernetes", "../../kube-system/pods/etcd-0"},
+		{"kubernetes", ".."},
+		{"kubernetes", "-fastdfs"},
+		{"kubernetes", "FastDFS-0"},
+		{"docker", "-v"},
+		{"docker", "a/b"},
+		{"ssh", "-oProxyCommand=touch /tmp/x"},
+		{"ssh", "host name"},
+		{"tracker", "10.0.0.1"},
+		{"tracker", "10.0.0.1:0"},
+		{"tracker", "-o:22122"},
+	} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?module="+c.module+"&target="+url.QueryEscape(c.target), nil))
+		if rec.Code != 400 {
+			t.Errorf("module %s accepted target %q with %d", c.module, c.target, rec.Code)
+		}
+	}
+
+	for _, c := range []struct {
+		module, target string
+	}{
+		{"kubernetes", "fastdfs-0"},
+		{"kubernetes", "fastdfs.storage-0"},
+		{"docker", "fastdfs_storage.1"},
+		{"ssh", "storage-0.example.com"},
+		{"ssh", "10.0.0.1"},
+		{"tracker", "tracker-0:22122"},
+		{"tracker", "[::1]:22122"},
+	} {
+		if err := modules[c.module].checkTarget(c.target); err != nil {
+			t.Errorf("module %s refused target %q: %v", c.module, c.target, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// reload.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+// clusterSet collects from every configured cluster at once.
+type clusterSet struct {
+	mu        sync.RWMutex
+	exporters []*Exporter
+}
+
+// update replaces the exporters, moving background collection and the
+// canaries over to the new ones.
+func (s *clusterSet) update(exporters []*Exporter) {
+	for _, e := range exporters {
+		e.start()
+	}
+	s.mu.Lock()
+	old := s.exporters
+	s.exporters = exporters
+	s.mu.Unlock()
+	for _, e := range old {
+		e.stop()
+	}
+}
+
+func (s *clusterSet) Describe(ch chan<- *prometheus.Desc) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, e := range s.exporters {
+		e.Describe(ch)
+	}
+}
+
+func (s *clusterSet) Collect(ch chan<- prometheus.Metric) {
+	s.CollectContext(context.Background(), ch)
+}
+
+// CollectContext collects from all clusters in parallel, bound to the
+// context of a scrape.
+func (s *clusterSet) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	exporters := s.exporters
+	s.mu.RUnlock()
+	var wg sync.WaitGroup
+	for _, e := range exporters {
+		wg.Add(1)
+		go func(e *Exporter) {
+			defer wg.Done()
+			e.CollectContext(ctx, ch)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// reloader loads the config file into the clusters collected by /metrics
+// and the /probe modules. It reloads on SIGHUP and on POST /-/reload; a
+// file that fails to load leaves the running configuration in place.
+type reloader struct {
+	path          string
+	defaultTarget *target
+
+	mu       sync.Mutex
+	clusters clusterSet
+	prober   prober
+}
+
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	exporters, modules, err := loadConfig(r.path, r.defaultTarget)
+	if err != nil {
+		return err
+	}
+	r.clusters.update(exporters)
+	r.prober.update(modules)
+	return nil
+}
+
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := r.reload(); err != nil {
+			log.Errorf("Reloading %s failed: %v", r.path, err)
+			continue
+		}
+		log.Infoln("Reloaded", r.path)
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		log.Errorf("Reloading %s failed: %v", r.path, err)
+		http.Error(w, fmt.Sprintf("Reloading %s failed: %v", r.path, err), http.StatusInternalServerError)
+		return
+	}
+	log.Infoln("Reloaded", r.path)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+)
+
+const validConfig = `
+clusters:
+  - name: production
+    executor: local
+    tracker_servers: [10.0.0.1:22122]
+modules:
+  tracker:
+    prober: tracker
+`
+
+func TestReloadKeepsConfigOnError(t *testing.T) {
+	dir, path := writeConfigFile(t, validConfig)
+	defer os.RemoveAll(dir)
+	r := &reloader{
+		path:          path,
+		defaultTarget: &target{config: FastDFSConfig{Executor: "local"}, executor: executor.NewFake()},
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	exporters, modules := r.clusters.exporters, r.prober.modules
+	defer r.clusters.update(nil)
+	if len(exporters) != 1 || len(modules) != 2 {
+		t.Fatalf("got %d exporters and %d modules, want 1 and 2", len(exporters), len(modules))
+	}
+
+	for _, c := range []struct {
+		name, content string
+	}{
+		{"broken yaml", "clusters: [name: production"},
+		{"unknown collector", "clusters:\n  - name: production\n    collectors: [nginx]\n"},
+		{"invalid cluster", "clusters:\n  - name: production\n    executor: local\n    http_probe: true\n"},
+		{"invalid module", "modules:\n  m:\n    executor: telnet\n"},
+	} {
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.reload(); err == nil {
+			t.Errorf("%s: reload succeeded", c.name)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+		if rec.Code != 500 {
+			t.Errorf("%s: POST /-/reload answered %d, want 500", c.name, rec.Code)
+		}
+		if len(r.clusters.exporters) != 1 || r.clusters.exporters[0] != exporters[0] {
+			t.Errorf("%s: the exporters were replaced", c.name)
+		}
+		if len(r.prober.modules) != 2 || r.prober.modules["tracker"] != modules["tracker"] {
+			t.Errorf("%s: the modules were replaced", c.name)
+		}
+	}
+
+	os.Remove(path)
+	if err := r.reload(); err == nil {
+		t.Error("reload of a missing file succeeded")
+	}
+
+	// A valid file replaces the configuration and stops the old exporters.
+	if err := ioutil.WriteFile(path, []byte(validConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+	if rec.Code != 200 {
+		t.Errorf("POST /-/reload answered %d: %s", rec.Code, rec.Body)
+	}
+	if r.clusters.exporters[0] == exporters[0] {
+		t.Error("a valid file did not replace the exporters")
+	}
+	select {
+	case <-exporters[0].done:
+	default:
+		t.Error("the replaced exporter was not stopped")
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/-/reload", nil))
+	if rec.Code != 405 {
+		t.Errorf("GET /-/reload answered %d, want 405", rec.Code)
+	}
+}
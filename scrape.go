@@ -0,0 +1,237 @@
+// scrape.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/kube"
+)
+
+// Stages of a collection.
+const (
+	stageDiscovery = "discovery" // resolving pod and tracker selectors
+	stageTracker   = "tracker"   // querying the trackers
+	stageMonitor   = "monitor"   // running fdfs_monitor
+	stageParse     = "parse"     // parsing the fdfs_monitor output
+	stageConfig    = "config"    // reading FastDFS.json
+	stageLiveness  = "liveness"  // sending ACTIVE_TEST to the storage servers
+)
+
+var (
+	up = newDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"Whether the topology of the cluster could be fetched at the last collection.",
+		nil, nil,
+	)
+	stageDuration = newDesc(
+		prometheus.BuildFQName(namespace, "scrape", "stage_duration_seconds"),
+		"How long each stage of the last collection took.",
+		[]string{"stage"}, nil,
+	)
+	scrapeErrors = newDesc(
+		prometheus.BuildFQName(namespace, "scrape", "errors_total"),
+		"Number of failed backend operations, by stage and cause.",
+		[]string{"stage", "cause"}, nil,
+	)
+	probeErrors = newDesc(
+		prometheus.BuildFQName(namespace, "probe", "errors"),
+		"Number of failed backend operations during the probe, by stage and cause.",
+		[]string{"stage", "cause"}, nil,
+	)
+)
+
+var (
+	// stageTimeouts bound each stage of a collection on top of the
+	// deadline of the scrape. Parsing needs no bound of its own.
+	stageTimeouts = map[string]time.Duration{}
+	// timeoutOffset is subtracted from the scrape timeout Prometheus sends,
+	// leaving time to encode and send the response.
+	timeoutOffset time.Duration
+)
+
+func stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc) {
+	if d := stageTimeouts[stage]; d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// scrapeContext returns the context of a scrape, which ends with the
+// request or when the X-Prometheus-Scrape-Timeout-Seconds header says
+// Prometheus gives up on it.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err == nil && seconds > 0 {
+			timeout := time.Duration(seconds*float64(time.Second)) - timeoutOffset
+			if timeout <= 0 {
+				timeout = time.Duration(seconds * float64(time.Second))
+			}
+			return context.WithTimeout(r.Context(), timeout)
+		}
+	}
+	return context.WithCancel(r.Context())
+}
+
+// contextCollector is a collector that can be bound to the context of a
+// scrape, which the Collector interface has no room for.
+type contextCollector interface {
+	Describe(chan<- *prometheus.Desc)
+	CollectContext(context.Context, chan<- prometheus.Metric)
+}
+
+// scrape collects from a contextCollector within ctx.
+type scrape struct {
+	ctx       context.Context
+	collector contextCollector
+}
+
+func (s scrape) Describe(ch chan<- *prometheus.Desc) {
+	s.collector.Describe(ch)
+}
+
+func (s scrape) Collect(ch chan<- prometheus.Metric) {
+	s.collector.CollectContext(s.ctx, ch)
+}
+
+type stageError struct {
+	stage string
+	cause string
+}
+
+// scrapeStats records how the stages of one collection went.
+type scrapeStats struct {
+	durations map[string]time.Duration
+	errors    []stageError
+}
+
+func newScrapeStats() *scrapeStats {
+	return &scrapeStats{durations: map[string]time.Duration{}}
+}
+
+// observe adds the time since start to stage. Stages that are retried on
+// another target add up.
+func (s *scrapeStats) observe(stage string, start time.Time) {
+	s.durations[stage] += time.Since(start)
+}
+
+func (s *scrapeStats) fail(stage string, err error) {
+	s.errors = append(s.errors, stageError{stage, errorCause(stage, err)})
+}
+
+// errorCause classifies err for the cause label of scrapeErrors.
+func errorCause(stage string, err error) string {
+	if stage == stageParse {
+		return "parse_error"
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return "timeout"
+	case context.Canceled:
+		return "canceled"
+	}
+	switch e := err.(type) {
+	case noPodsError:
+		return "not_found"
+	case *kube.StatusError:
+		switch e.Code {
+		case 401, 403:
+			return "auth_denied"
+		case 404:
+			return "not_found"
+		}
+		return "command_failed"
+	case *executor.CommandError:
+		stderr := strings.ToLower(e.Stderr)
+		switch {
+		case strings.Contains(stderr, "no such container"), strings.Contains(stderr, "no such file"):
+			return "not_found"
+		case strings.Contains(stderr, "permission denied"):
+			return "auth_denied"
+		case strings.Contains(stderr, "timed out"):
+			return "timeout"
+		case strings.Contains(stderr, "could not resolve"), strings.Contains(stderr, "connection refused"), strings.Contains(stderr, "no route to host"):
+			return "unreachable"
+		}
+		return "command_failed"
+	case *fdfs.StatusError:
+		return "server_error"
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return "parse_error"
+	case *url.Error:
+		return errorCause(stage, e.Err)
+	}
+	if e, ok := err.(net.Error); ok {
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "unreachable"
+	}
+	return "other"
+}
+
+// errorCounter keeps scrapeErrors across collections.
+type errorCounter struct {
+	mu     sync.Mutex
+	counts map[stageError]float64
+}
+
+func (c *errorCounter) add(errors []stageError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = map[stageError]float64{}
+	}
+	for _, e := range errors {
+		c.counts[e]++
+	}
+}
+
+// scrapeMetrics returns the metrics describing a collection with stats,
+// which failed if err is set. Probes get the errors of the collection alone,
+// as each one collects afresh.
+func (e *Exporter) scrapeMetrics(stats *scrapeStats, err error) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	var upValue float64
+	if err == nil {
+		upValue = 1
+	}
+	metrics = append(metrics, prometheus.MustNewConstMetric(up, prometheus.GaugeValue, upValue))
+	for stage, d := range stats.durations {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			stageDuration, prometheus.GaugeValue, d.Seconds(), stage,
+		))
+	}
+	if e.probe {
+		counts := map[stageError]float64{}
+		for _, se := range stats.errors {
+			counts[se]++
+		}
+		for se, n := range counts {
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				probeErrors, prometheus.GaugeValue, n, se.stage, se.cause,
+			))
+		}
+		return metrics
+	}
+	e.errors.add(stats.errors)
+	e.errors.mu.Lock()
+	defer e.errors.mu.Unlock()
+	for se, n := range e.errors.counts {
+		metrics = append(metrics, prometheus.MustNewConstMetric(
+			scrapeErrors, prometheus.CounterValue, n, se.stage, se.cause,
+		))
+	}
+	return metrics
+}
@@ -0,0 +1,220 @@
+// storage.go
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	storageLabels = []string{"group", "storage_id", "ip"}
+	storageStates = []string{
+		"INIT", "WAIT_SYNC", "SYNCING", "IP_CHANGED", "DELETED",
+		"OFFLINE", "ONLINE", "ACTIVE", "RECOVERY",
+	}
+	storageStatus = newDesc(
+		prometheus.BuildFQName(namespace, "storage", "status"),
+		"Status of the storage server as reported by the tracker, one series per state.",
+		withLabels(storageLabels, "status"), nil,
+	)
+	storageOperations = []string{
+		"upload", "append", "modify", "truncate", "set_meta", "delete",
+		"download", "get_meta", "create_link", "delete_link",
+	}
+	storageOperationsTotal = newDesc(
+		prometheus.BuildFQName(namespace, "storage", "operations_total"),
+		"Number of operations handled by the storage server, by operation and result.",
+		withLabels(storageLabels, "operation", "result"), nil,
+	)
+	storageByteCounters = []struct {
+		desc *prometheus.Desc
+		name string
+	}{
+		{newStorageBytesDesc("upload", "Bytes uploaded to the storage server."), "upload"},
+		{newStorageBytesDesc("append", "Bytes appended to files on the storage server."), "append"},
+		{newStorageBytesDesc("modify", "Bytes written by modify requests on the storage server."), "modify"},
+		{newStorageBytesDesc("download", "Bytes downloaded from the storage server."), "download"},
+		{newStorageBytesDesc("sync_in", "Bytes received from group peers through replication."), "sync_in"},
+		{newStorageBytesDesc("sync_out", "Bytes sent to group peers through replication."), "sync_out"},
+	}
+	storageFileOperations      = []string{"open", "read", "write"}
+	storageFileOperationsTotal = newDesc(
+		prometheus.BuildFQName(namespace, "storage", "file_operations_total"),
+		"Number of file I/O operations performed by the storage server, by operation and result.",
+		withLabels(storageLabels, "operation", "result"), nil,
+	)
+	storageTimestamps = []struct {
+		desc *prometheus.Desc
+		key  string
+	}{
+		{newStorageDesc("last_source_update_timestamp_seconds", "Last time a client updated a file on the storage server, 0 if never."), "last_source_update"},
+		{newStorageDesc("last_sync_update_timestamp_seconds", "Last time the storage server received a file from a group peer, 0 if never."), "last_sync_update"},
+		{newStorageDesc("last_synced_timestamp_seconds", "Source timestamp up to which the storage server has synced from its group peers, 0 if never."), "last_synced_timestamp"},
+	}
+	storageSyncDelay = newStorageDesc(
+		"sync_delay_seconds",
+		"How far the storage server trails the latest source update of its group peers.",
+	)
+	storageLastHeartbeat = newStorageDesc(
+		"last_heartbeat_timestamp_seconds",
+		"Last time the storage server sent a heartbeat to the tracker.",
+	)
+	storageHeartbeatAge = newStorageDesc(
+		"heartbeat_age_seconds",
+		"Time since the last heartbeat of the storage server, by the clock of the host that listed the topology.",
+	)
+	storageClockSkew = newStorageDesc(
+		"clock_skew_seconds",
+		"Latest update stamped by the storage server minus the tracker's time of its last heartbeat. Positive values mean the storage clock runs ahead by at least that much.",
+	)
+)
+
+func newStorageDesc(name, help string) *prometheus.Desc {
+	return newDesc(
+		prometheus.BuildFQName(namespace, "storage", name),
+		help, storageLabels, nil,
+	)
+}
+
+func newStorageBytesDesc(name, help string) *prometheus.Desc {
+	return newDesc(
+		prometheus.BuildFQName(namespace, "storage", name+"_bytes_total"),
+		help, withLabels(storageLabels, "result"), nil,
+	)
+}
+
+// withLabels returns a copy of labels extended with extra.
+func withLabels(labels []string, extra ...string) []string {
+	return append(append([]string{}, labels...), extra...)
+}
+
+func describeStorageMetrics(ch chan<- *prometheus.Desc) {
+	ch <- storageStatus
+	ch <- storageOperationsTotal
+	for _, c := range storageByteCounters {
+		ch <- c.desc
+	}
+	ch <- storageFileOperationsTotal
+	for _, t := range storageTimestamps {
+		ch <- t.desc
+	}
+	ch <- storageSyncDelay
+	ch <- storageLastHeartbeat
+	ch <- storageHeartbeatAge
+	ch <- storageClockSkew
+}
+
+func collectStorageMetrics(ch chan<- prometheus.Metric, cluster *Cluster) {
+	now := cluster.Now()
+	for _, g := range cluster.Groups {
+		for _, s := range g.Storages {
+			collectStorage(ch, g, s, now)
+		}
+	}
+}
+
+func collectStorage(ch chan<- prometheus.Metric, g *Group, s *Storage, now time.Time) {
+	known := false
+	for _, state := range storageStates {
+		value := 0.0
+		if s.Status == state {
+			value = 1
+			known = true
+		}
+		ch <- prometheus.MustNewConstMetric(
+			storageStatus, prometheus.GaugeValue, value, s.Group, s.ID, s.IP, state,
+		)
+	}
+	if !known && s.Status != "" {
+		ch <- prometheus.MustNewConstMetric(
+			storageStatus, prometheus.GaugeValue, 1, s.Group, s.ID, s.IP, s.Status,
+		)
+	}
+	for _, op := range storageOperations {
+		collectSuccessFailure(ch, storageOperationsTotal, s, fmt.Sprintf("%s_count", op), op)
+	}
+	for _, c := range storageByteCounters {
+		collectSuccessFailure(ch, c.desc, s, fmt.Sprintf("%s_bytes", c.name))
+	}
+	for _, op := range storageFileOperations {
+		collectSuccessFailure(ch, storageFileOperationsTotal, s, fmt.Sprintf("file_%s_count", op), op)
+	}
+	for _, ts := range storageTimestamps {
+		if t, ok := s.Time(ts.key); ok {
+			ch <- prometheus.MustNewConstMetric(
+				ts.desc, prometheus.GaugeValue, float64(t.Unix()), s.Group, s.ID, s.IP,
+			)
+		}
+	}
+	if delay, ok := g.SyncDelay(s); ok {
+		ch <- prometheus.MustNewConstMetric(
+			storageSyncDelay, prometheus.GaugeValue, delay.Seconds(), s.Group, s.ID, s.IP,
+		)
+	}
+	if beat, ok := s.Time("last_heart_beat_time"); ok && beat.Unix() != 0 {
+		// The tracker stamps heartbeats with its own clock, which may run
+		// ahead of the host that listed the topology.
+		age := now.Sub(beat)
+		if age < 0 {
+			age = 0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			storageLastHeartbeat, prometheus.GaugeValue, float64(beat.Unix()), s.Group, s.ID, s.IP,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			storageHeartbeatAge, prometheus.GaugeValue, age.Seconds(), s.Group, s.ID, s.IP,
+		)
+	}
+	if skew, ok := clockSkew(s); ok {
+		ch <- prometheus.MustNewConstMetric(
+			storageClockSkew, prometheus.GaugeValue, skew.Seconds(), s.Group, s.ID, s.IP,
+		)
+	}
+}
+
+// clockSkew compares the latest update the storage server stamped with its
+// own clock against the time the tracker, by its clock, received the last
+// heartbeat, which carried that update. An update can't happen after the
+// heartbeat reporting it, so a positive result is a lower bound of how far
+// the storage clock runs ahead. A negative one also counts the time between
+// the update and the heartbeat, so a clock running behind can't be told
+// apart from a storage that has been idle. It reports false until the
+// storage server has had an update.
+func clockSkew(s *Storage) (time.Duration, bool) {
+	beat, ok := s.Time("last_heart_beat_time")
+	if !ok || beat.Unix() == 0 {
+		return 0, false
+	}
+	var latest time.Time
+	for _, key := range []string{"last_source_update", "last_sync_update"} {
+		if t, ok := s.Time(key); ok && t.Unix() != 0 && t.After(latest) {
+			latest = t
+		}
+	}
+	if latest.IsZero() {
+		return 0, false
+	}
+	return latest.Sub(beat), true
+}
+
+// collectSuccessFailure exports the total_<name> and success_<name> pair of a
+// storage as success and failure counters.
+func collectSuccessFailure(ch chan<- prometheus.Metric, desc *prometheus.Desc, s *Storage, name string, labels ...string) {
+	total, ok := s.Fields.Int("total_" + name)
+	if !ok {
+		// fdfs_monitor misspells total_download_bytes as stotal_download_bytes.
+		if total, ok = s.Fields.Int("stotal_" + name); !ok {
+			return
+		}
+	}
+	success, _ := s.Fields.Int("success_" + name)
+	labels = append([]string{s.Group, s.ID, s.IP}, labels...)
+	ch <- prometheus.MustNewConstMetric(
+		desc, prometheus.CounterValue, float64(success), append(labels, "success")...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		desc, prometheus.CounterValue, float64(total-success), append(labels, "failure")...,
+	)
+}
@@ -0,0 +1,87 @@
+// target.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/kube"
+)
+
+// target is one FastDFS deployment to collect from, along with the clients
+// its configuration calls for. location is the time zone fdfs_monitor prints
+// times in, nil to derive it from the output.
+type target struct {
+	config   FastDFSConfig
+	kube     *kube.Client
+	executor executor.Executor
+	location *time.Location
+}
+
+func newTarget(c FastDFSConfig) (*target, error) {
+	if c.CanaryInterval > 0 && (c.CanaryTimeout <= 0 || c.CanarySize <= 0) {
+		return nil, fmt.Errorf("the canary needs a positive timeout and size")
+	}
+	if (c.HTTPProbe || c.HTTPProbeFile != "") && c.CanaryInterval <= 0 {
+		return nil, fmt.Errorf("http_probe and http_probe_file run on the canary schedule and need a positive canary_interval")
+	}
+	if c.HTTPProbeFile != "" {
+		if _, _, err := splitFileID(c.HTTPProbeFile); err != nil {
+			return nil, fmt.Errorf("http_probe_file: %v", err)
+		}
+	}
+	var location *time.Location
+	if c.MonitorTimezone != "" {
+		var err error
+		if location, err = parseTimezone(c.MonitorTimezone); err != nil {
+			return nil, fmt.Errorf("monitor_timezone: %v", err)
+		}
+	}
+	t := &target{config: c, location: location}
+	// Only build the client when it is used, so the other executors start
+	// without Kubernetes credentials even inside a pod.
+	if c.Executor == "kubernetes" || c.PodSelector != "" || c.TrackerSelector != "" {
+		kc, err := newKubeConfig(c)
+		if err != nil {
+			return nil, fmt.Errorf("configuring the Kubernetes client failed: %v", err)
+		}
+		if t.kube, err = kube.NewClient(kc); err != nil {
+			return nil, fmt.Errorf("configuring the Kubernetes client failed: %v", err)
+		}
+	}
+	switch c.Executor {
+	case "kubernetes":
+		t.executor = executor.Kubernetes{
+			Client:    t.kube,
+			Namespace: c.NameSpace,
+			Container: c.Container,
+		}
+	case "docker":
+		t.executor = executor.Docker{}
+	case "ssh":
+		t.executor = executor.SSH{
+			User:         c.SSHUser,
+			Port:         c.SSHPort,
+			IdentityFile: c.SSHIdentityFile,
+		}
+	case "local":
+		t.executor = executor.Local{}
+	default:
+		return nil, fmt.Errorf("unknown executor %q, want kubernetes, docker, ssh or local", c.Executor)
+	}
+	return t, nil
+}
+
+// fastdfsTargets returns the pods, containers or hosts to run commands on,
+// in the order they should be tried.
+func (t *target) fastdfsTargets(ctx context.Context) ([]string, error) {
+	if t.config.Executor == "kubernetes" {
+		return t.fastdfsPods(ctx)
+	}
+	if len(t.config.Targets) > 0 {
+		return t.config.Targets, nil
+	}
+	return []string{t.config.PodName}, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewTargetHTTPProbeNeedsCanary(t *testing.T) {
+	for _, c := range []FastDFSConfig{
+		{Executor: "local", HTTPProbe: true},
+		{Executor: "local", HTTPProbeFile: "group1/M00/00/00/file.txt"},
+	} {
+		if _, err := newTarget(c); err == nil {
+			t.Errorf("newTarget accepted an HTTP probe without a canary: %+v", c)
+		}
+	}
+	c := FastDFSConfig{
+		Executor:       "local",
+		HTTPProbe:      true,
+		CanaryInterval: time.Minute,
+		CanaryTimeout:  time.Second,
+		CanarySize:     16,
+	}
+	if _, err := newTarget(c); err != nil {
+		t.Errorf("newTarget refused an HTTP probe with a canary: %v", err)
+	}
+}
+
+// Inside a pod without a mounted service account, only targets that use
+// Kubernetes need its credentials.
+func TestNewTargetBuildsKubeClientOnlyWhenUsed(t *testing.T) {
+	for _, name := range []string{"KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	for _, executor := range []string{"docker", "ssh", "local"} {
+		tg, err := newTarget(FastDFSConfig{Executor: executor, Targets: []string{"fastdfs"}})
+		if err != nil {
+			t.Errorf("newTarget with the %s executor: %v", executor, err)
+			continue
+		}
+		if tg.kube != nil {
+			t.Errorf("newTarget with the %s executor built a Kubernetes client", executor)
+		}
+	}
+	if _, err := os.Stat("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"); err == nil {
+		return
+	}
+	for _, c := range []FastDFSConfig{
+		{Executor: "kubernetes", PodName: "fastdfs"},
+		{Executor: "local", TrackerSelector: "app=tracker"},
+	} {
+		if _, err := newTarget(c); err == nil {
+			t.Errorf("newTarget built a Kubernetes client without a service account: %+v", c)
+		}
+	}
+}
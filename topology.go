@@ -0,0 +1,113 @@
+// topology.go
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	topologyMismatch = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "topology_mismatch"),
+		"How many groups and storage servers two trackers disagree on, by membership or status.",
+		[]string{"tracker", "peer"}, nil,
+	)
+	storageStatusDisagreement = newDesc(
+		prometheus.BuildFQName(namespace, "storage", "status_disagreement"),
+		"Whether the reachable trackers disagree on the membership or status of the storage server.",
+		storageLabels, nil,
+	)
+)
+
+// topologyView maps every group and storage a tracker knows about to the
+// status it reports. Groups map to an empty status.
+type topologyView map[string]string
+
+func newTopologyView(c *Cluster) topologyView {
+	view := topologyView{}
+	for _, g := range c.Groups {
+		view[g.Name] = ""
+		for _, s := range g.Storages {
+			view[g.Name+"/"+s.ID] = s.Status
+		}
+	}
+	return view
+}
+
+// diff returns how many entries are missing from either view or carry a
+// different status.
+func (v topologyView) diff(peer topologyView) int {
+	n := 0
+	for key, status := range v {
+		if peerStatus, ok := peer[key]; !ok || peerStatus != status {
+			n++
+		}
+	}
+	for key := range peer {
+		if _, ok := v[key]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+func describeTopologyMetrics(ch chan<- *prometheus.Desc) {
+	ch <- topologyMismatch
+	ch <- storageStatusDisagreement
+}
+
+// collectTopologyMetrics compares the topology reported by every tracker that
+// answered. It has nothing to say unless at least two did.
+func collectTopologyMetrics(ch chan<- prometheus.Metric, scrapes []trackerScrape) {
+	var (
+		addrs []string
+		views []topologyView
+	)
+	for _, t := range scrapes {
+		if t.err == nil && t.cluster != nil {
+			addrs = append(addrs, t.addr)
+			views = append(views, newTopologyView(t.cluster))
+		}
+	}
+	if len(views) < 2 {
+		return
+	}
+	for i := range views {
+		for j := i + 1; j < len(views); j++ {
+			ch <- prometheus.MustNewConstMetric(
+				topologyMismatch, prometheus.GaugeValue, float64(views[i].diff(views[j])), addrs[i], addrs[j],
+			)
+		}
+	}
+
+	storages := map[string]*Storage{}
+	for _, t := range scrapes {
+		if t.err != nil || t.cluster == nil {
+			continue
+		}
+		for _, s := range t.cluster.Storages() {
+			if _, ok := storages[s.Group+"/"+s.ID]; !ok {
+				storages[s.Group+"/"+s.ID] = s
+			}
+		}
+	}
+	keys := make([]string, 0, len(storages))
+	for key := range storages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := storages[key]
+		disagree := 0.0
+		for _, view := range views {
+			if status, ok := view[key]; !ok || status != s.Status {
+				disagree = 1
+				break
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			storageStatusDisagreement, prometheus.GaugeValue, disagree, s.Group, s.ID, s.IP,
+		)
+	}
+}
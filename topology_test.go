@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+func TestCollectTopologyMetrics(t *testing.T) {
+	t1 := newFakeTracker(t)
+	defer t1.Close()
+	t2 := newFakeTracker(t)
+	defer t2.Close()
+	// t3 sees s1 go offline and an s2 the others do not know about yet.
+	t3 := newStatusTracker(t, fdfs.TrackerStatus{},
+		fdfs.StorageStat{Status: fdfs.StorageStatusOffline, ID: "s1", IPAddr: "10.0.0.1", StoragePort: 23000},
+		fdfs.StorageStat{Status: fdfs.StorageStatusActive, ID: "s2", IPAddr: "10.0.0.2", StoragePort: 23000},
+	)
+	defer t3.Close()
+	down := newFakeTracker(t)
+	down.Close()
+
+	e := newTrackersExporter(t, []string{"topology"}, t1.Addr, t2.Addr, t3.Addr, down.Addr)
+	metrics := collectAll(context.Background(), e)
+	if got, want := values(t, metrics, topologyMismatch), map[string]float64{
+		"peer=" + t2.Addr + ",tracker=" + t1.Addr: 0,
+		"peer=" + t3.Addr + ",tracker=" + t1.Addr: 2,
+		"peer=" + t3.Addr + ",tracker=" + t2.Addr: 2,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatch = %v, want %v", got, want)
+	}
+	if got, want := values(t, metrics, storageStatusDisagreement), map[string]float64{
+		"group=group1,ip=10.0.0.1,storage_id=s1": 1,
+		"group=group1,ip=10.0.0.2,storage_id=s2": 1,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("disagreement = %v, want %v", got, want)
+	}
+
+	// Trackers that agree report no disagreement.
+	metrics = collectAll(context.Background(), newTrackersExporter(t, []string{"topology"}, t1.Addr, t2.Addr))
+	if got, want := values(t, metrics, storageStatusDisagreement), map[string]float64{
+		"group=group1,ip=10.0.0.1,storage_id=s1": 0,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("disagreement between agreeing trackers = %v, want %v", got, want)
+	}
+}
+
+func TestCollectTopologyMetricsSingleTracker(t *testing.T) {
+	tracker := newFakeTracker(t)
+	defer tracker.Close()
+	down := newFakeTracker(t)
+	down.Close()
+
+	metrics := collectAll(context.Background(), newTrackersExporter(t, []string{"topology"}, tracker.Addr, down.Addr))
+	for _, desc := range []*prometheus.Desc{topologyMismatch, storageStatusDisagreement} {
+		if got := values(t, metrics, desc); len(got) != 0 {
+			t.Errorf("%v = %v with a single tracker answering, want nothing", desc, got)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// tracker.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+)
+
+var (
+	trackerLabels = []string{"tracker"}
+	trackerUp     = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "up"),
+		"Whether the last query of the tracker succeeded.",
+		trackerLabels, nil,
+	)
+	trackerScrapeDuration = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "scrape_duration_seconds"),
+		"How long the last query of the tracker took.",
+		trackerLabels, nil,
+	)
+	trackerIsLeader = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "is_leader"),
+		"Whether the tracker claims to be the cluster leader.",
+		trackerLabels, nil,
+	)
+	trackerUptime = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "uptime_seconds"),
+		"How long the tracker has been running.",
+		trackerLabels, nil,
+	)
+	trackerRestartInterval = newDesc(
+		prometheus.BuildFQName(namespace, "tracker", "restart_interval_seconds"),
+		"How long the tracker was down before its last start.",
+		trackerLabels, nil,
+	)
+	trackerLeaders = newDesc(
+		prometheus.BuildFQName(namespace, "", "tracker_leaders"),
+		"How many of the reachable trackers claim to be the leader.",
+		nil, nil,
+	)
+)
+
+// trackerScrape is the outcome of querying one tracker.
+type trackerScrape struct {
+	addr     string
+	cluster  *Cluster
+	status   *fdfs.TrackerStatus
+	err      error
+	duration time.Duration
+}
+
+// scrapeTrackers queries every tracker concurrently. The results keep the
+// order of addrs.
+func scrapeTrackers(ctx context.Context, addrs []string) []trackerScrape {
+	scrapes := make([]trackerScrape, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(t *trackerScrape, addr string) {
+			defer wg.Done()
+			start := time.Now()
+			t.addr = addr
+			t.cluster, t.status, t.err = queryTracker(ctx, addr)
+			t.duration = time.Since(start)
+		}(&scrapes[i], addr)
+	}
+	wg.Wait()
+	return scrapes
+}
+
+// withTracker calls fn with the first of addrs that can be reached and
+// answers it.
+func withTracker(ctx context.Context, addrs []string, fn func(*fdfs.Tracker) error) error {
+	err := fmt.Errorf("no tracker configured")
+	for _, addr := range addrs {
+		var tracker *fdfs.Tracker
+		if tracker, err = fdfs.DialTrackerContext(ctx, addr); err != nil {
+			continue
+		}
+		err = fn(tracker)
+		tracker.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func describeTrackerMetrics(ch chan<- *prometheus.Desc) {
+	ch <- trackerUp
+	ch <- trackerScrapeDuration
+	ch <- trackerIsLeader
+	ch <- trackerUptime
+	ch <- trackerRestartInterval
+	ch <- trackerLeaders
+}
+
+func collectTrackerMetrics(ch chan<- prometheus.Metric, scrapes []trackerScrape) {
+	if len(scrapes) == 0 {
+		return
+	}
+	leaders := 0
+	for _, t := range scrapes {
+		up := 1.0
+		if t.err != nil {
+			up = 0
+		}
+		ch <- prometheus.MustNewConstMetric(trackerUp, prometheus.GaugeValue, up, t.addr)
+		ch <- prometheus.MustNewConstMetric(trackerScrapeDuration, prometheus.GaugeValue, t.duration.Seconds(), t.addr)
+		if t.status == nil {
+			continue
+		}
+		isLeader := 0.0
+		if t.status.IfLeader {
+			isLeader = 1
+			leaders++
+		}
+		ch <- prometheus.MustNewConstMetric(trackerIsLeader, prometheus.GaugeValue, isLeader, t.addr)
+		ch <- prometheus.MustNewConstMetric(trackerUptime, prometheus.GaugeValue, float64(t.status.RunningTime), t.addr)
+		ch <- prometheus.MustNewConstMetric(trackerRestartInterval, prometheus.GaugeValue, float64(t.status.RestartInterval), t.addr)
+	}
+	ch <- prometheus.MustNewConstMetric(trackerLeaders, prometheus.GaugeValue, float64(leaders))
+}
+
+// queryTracker builds a Cluster by talking the tracker protocol to addr. The
+// fields are keyed the way fdfs_monitor prints them, so metrics do not care
+// which source the model came from. The tracker's own status is returned
+// alongside, or nil if the tracker would not report it.
+func queryTracker(ctx context.Context, addr string) (*Cluster, *fdfs.TrackerStatus, error) {
+	tracker, err := fdfs.DialTrackerContext(ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tracker.Close()
+	cluster, status, err := listTracker(tracker, addr)
+	// A done context closes the connection, which shows up as a read error.
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return cluster, status, err
+}
+
+func listTracker(tracker *fdfs.Tracker, addr string) (*Cluster, *fdfs.TrackerStatus, error) {
+	var status *fdfs.TrackerStatus
+	if s, err := tracker.Status(); err != nil {
+		log.Errorf("Getting status of tracker %s failed: %v", addr, err)
+		if _, ok := err.(*fdfs.StatusError); !ok {
+			return nil, nil, err
+		}
+	} else {
+		status = &s
+	}
+
+	groups, err := tracker.ListGroups()
+	if err != nil {
+		return nil, status, err
+	}
+	cluster := &Cluster{
+		Time:       time.Now(),
+		Trackers:   []string{addr},
+		GroupCount: len(groups),
+	}
+	for _, gs := range groups {
+		storages, err := tracker.ListStorage(gs.Name, "")
+		if err != nil {
+			return nil, status, err
+		}
+		group := groupFromStat(gs)
+		for _, ss := range storages {
+			group.Storages = append(group.Storages, storageFromStat(gs.Name, ss))
+		}
+		cluster.Groups = append(cluster.Groups, group)
+	}
+	return cluster, status, nil
+}
+
+func groupFromStat(g fdfs.GroupStat) *Group {
+	return &Group{
+		Name: g.Name,
+		Fields: Fields{
+			"group name":                 g.Name,
+			"disk total space":           fmt.Sprintf("%d MB", g.TotalMB),
+			"disk free space":            fmt.Sprintf("%d MB", g.FreeMB),
+			"trunk free space":           fmt.Sprintf("%d MB", g.TrunkFreeMB),
+			"storage server count":       strconv.FormatInt(g.StorageCount, 10),
+			"active server count":        strconv.FormatInt(g.ActiveCount, 10),
+			"storage server port":        strconv.FormatInt(g.StoragePort, 10),
+			"storage HTTP port":          strconv.FormatInt(g.StorageHTTPPort, 10),
+			"store path count":           strconv.FormatInt(g.StorePathCount, 10),
+			"subdir count per path":      strconv.FormatInt(g.SubdirCountPerPath, 10),
+			"current write server index": strconv.FormatInt(g.CurrentWriteServer, 10),
+			"current trunk file id":      strconv.FormatInt(g.CurrentTrunkFileID, 10),
+		},
+	}
+}
+
+func storageFromStat(group string, s fdfs.StorageStat) *Storage {
+	c := s.StorageCounters
+	fields := Fields{
+		"id":                    s.ID,
+		"ip_addr":               s.IPAddr + "  " + s.Status.String(),
+		"http domain":           s.DomainName,
+		"version":               s.Version,
+		"join time":             monitorTime(s.JoinTime),
+		"up time":               monitorTime(s.UpTime),
+		"total storage":         fmt.Sprintf("%d MB", s.TotalMB),
+		"free storage":          fmt.Sprintf("%d MB", s.FreeMB),
+		"source storage id":     s.SrcID,
+		"last_source_update":    monitorTime(c.LastSourceUpdate),
+		"last_sync_update":      monitorTime(c.LastSyncUpdate),
+		"last_synced_timestamp": monitorTime(c.LastSyncedTimestamp),
+		"last_heart_beat_time":  monitorTime(c.LastHeartBeatTime),
+	}
+	for key, value := range map[string]int64{
+		"upload priority":           s.UploadPriority,
+		"store_path_count":          s.StorePathCount,
+		"subdir_count_per_path":     s.SubdirCountPerPath,
+		"storage_port":              s.StoragePort,
+		"storage_http_port":         s.StorageHTTPPort,
+		"current_write_path":        s.CurrentWritePath,
+		"connection.alloc_count":    int64(c.ConnectionAllocCount),
+		"connection.current_count":  int64(c.ConnectionCurrentCount),
+		"connection.max_count":      int64(c.ConnectionMaxCount),
+		"total_upload_count":        c.TotalUploadCount,
+		"success_upload_count":      c.SuccessUploadCount,
+		"total_append_count":        c.TotalAppendCount,
+		"success_append_count":      c.SuccessAppendCount,
+		"total_modify_count":        c.TotalModifyCount,
+		"success_modify_count":      c.SuccessModifyCount,
+		"total_truncate_count":      c.TotalTruncateCount,
+		"success_truncate_count":    c.SuccessTruncateCount,
+		"total_set_meta_count":      c.TotalSetMetaCount,
+		"success_set_meta_count":    c.SuccessSetMetaCount,
+		"total_delete_count":        c.TotalDeleteCount,
+		"success_delete_count":      c.SuccessDeleteCount,
+		"total_download_count":      c.TotalDownloadCount,
+		"success_download_count":    c.SuccessDownloadCount,
+		"total_get_meta_count":      c.TotalGetMetaCount,
+		"success_get_meta_count":    c.SuccessGetMetaCount,
+		"total_create_link_count":   c.TotalCreateLinkCount,
+		"success_create_link_count": c.SuccessCreateLinkCount,
+		"total_delete_link_count":   c.TotalDeleteLinkCount,
+		"success_delete_link_count": c.SuccessDeleteLinkCount,
+		"total_upload_bytes":        c.TotalUploadBytes,
+		"success_upload_bytes":      c.SuccessUploadBytes,
+		"total_append_bytes":        c.TotalAppendBytes,
+		"success_append_bytes":      c.SuccessAppendBytes,
+		"total_modify_bytes":        c.TotalModifyBytes,
+		"success_modify_bytes":      c.SuccessModifyBytes,
+		"total_download_bytes":      c.TotalDownloadBytes,
+		"success_download_bytes":    c.SuccessDownloadBytes,
+		"total_sync_in_bytes":       c.TotalSyncInBytes,
+		"success_sync_in_bytes":     c.SuccessSyncInBytes,
+		"total_sync_out_bytes":      c.TotalSyncOutBytes,
+		"success_sync_out_bytes":    c.SuccessSyncOutBytes,
+		"total_file_open_count":     c.TotalFileOpenCount,
+		"success_file_open_count":   c.SuccessFileOpenCount,
+		"total_file_read_count":     c.TotalFileReadCount,
+		"success_file_read_count":   c.SuccessFileReadCount,
+		"total_file_write_count":    c.TotalFileWriteCount,
+		"success_file_write_count":  c.SuccessFileWriteCount,
+	} {
+		fields[key] = strconv.FormatInt(value, 10)
+	}
+	if s.IfTrunkServer {
+		fields["if_trunk_server"] = "1"
+	} else {
+		fields["if_trunk_server"] = "0"
+	}
+	return &Storage{
+		Group:    group,
+		ID:       s.ID,
+		IP:       s.IPAddr,
+		Status:   s.Status.String(),
+		Fields:   fields,
+		Location: time.UTC,
+	}
+}
+
+// monitorTime formats a Unix timestamp the way fdfs_monitor prints it, in
+// UTC.
+func monitorTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(monitorTimeLayout)
+}
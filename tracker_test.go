@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ruanchen/fastdfs-exporter/executor"
+	"github.com/ruanchen/fastdfs-exporter/fdfs"
+	"github.com/ruanchen/fastdfs-exporter/fdfs/fdfstest"
+)
+
+// newTrackersExporter returns an exporter querying all the trackers at addrs
+// with collectors.
+func newTrackersExporter(t *testing.T, collectors []string, addrs ...string) *Exporter {
+	tg := &target{
+		config:   FastDFSConfig{Executor: "local", TrackerServers: addrs},
+		executor: executor.NewFake(),
+	}
+	e, err := NewExporter(tg, nil, collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// newStatusTracker returns a fake tracker reporting status, serving group1
+// with storages.
+func newStatusTracker(t *testing.T, status fdfs.TrackerStatus, storages ...fdfs.StorageStat) *fdfstest.Tracker {
+	tracker, err := fdfstest.NewTracker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.SetStatus(status)
+	tracker.AddGroup(fdfs.GroupStat{Name: "group1", StorageCount: int64(len(storages)), StoragePort: 23000}, storages...)
+	return tracker
+}
+
+func TestCollectTrackerMetrics(t *testing.T) {
+	leader := newStatusTracker(t, fdfs.TrackerStatus{IfLeader: true, RunningTime: 3600, RestartInterval: 30})
+	defer leader.Close()
+	follower := newStatusTracker(t, fdfs.TrackerStatus{RunningTime: 60})
+	defer follower.Close()
+	down := newStatusTracker(t, fdfs.TrackerStatus{IfLeader: true})
+	down.Close()
+
+	e := newTrackersExporter(t, []string{"tracker"}, leader.Addr, follower.Addr, down.Addr)
+	metrics := collectAll(context.Background(), e)
+	for _, c := range []struct {
+		name string
+		got  map[string]float64
+		want map[string]float64
+	}{
+		{"up", values(t, metrics, trackerUp), map[string]float64{
+			"tracker=" + leader.Addr: 1, "tracker=" + follower.Addr: 1, "tracker=" + down.Addr: 0,
+		}},
+		{"is_leader", values(t, metrics, trackerIsLeader), map[string]float64{
+			"tracker=" + leader.Addr: 1, "tracker=" + follower.Addr: 0,
+		}},
+		{"uptime", values(t, metrics, trackerUptime), map[string]float64{
+			"tracker=" + leader.Addr: 3600, "tracker=" + follower.Addr: 60,
+		}},
+		{"restart interval", values(t, metrics, trackerRestartInterval), map[string]float64{
+			"tracker=" + leader.Addr: 30, "tracker=" + follower.Addr: 0,
+		}},
+		// The tracker that is down claims nothing.
+		{"leaders", values(t, metrics, trackerLeaders), map[string]float64{"": 1}},
+	} {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if got := values(t, metrics, trackerScrapeDuration); len(got) != 3 {
+		t.Errorf("scrape durations = %v, want one per tracker", got)
+	}
+}
+
+func TestCollectTrackerMetricsLeaderCount(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		leaders []bool
+		want    float64
+	}{
+		{"no leader", []bool{false, false}, 0},
+		{"split brain", []bool{true, true, false}, 2},
+	} {
+		var addrs []string
+		for _, isLeader := range c.leaders {
+			tracker := newStatusTracker(t, fdfs.TrackerStatus{IfLeader: isLeader})
+			defer tracker.Close()
+			addrs = append(addrs, tracker.Addr)
+		}
+		e := newTrackersExporter(t, []string{"tracker"}, addrs...)
+		if v, ok := gaugeValue(t, collectAll(context.Background(), e), trackerLeaders); !ok || v != c.want {
+			t.Errorf("%s: leaders = %v (found %v), want %v", c.name, v, ok, c.want)
+		}
+	}
+}